@@ -0,0 +1,25 @@
+package tcp
+
+import "hash/crc32"
+
+// checksumSize 帧末尾CRC32C校验和的长度
+const checksumSize = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// frameTailSize 帧末尾校验和的长度，未设置flagChecksum时为0
+func frameTailSize(flags uint8) int {
+	if flags&flagChecksum != 0 {
+		return checksumSize
+	}
+	return 0
+}
+
+// frameChecksum 计算帧的CRC32C，覆盖总长度之后的所有字段，依次传入帧的各部分
+func frameChecksum(parts ...[]byte) uint32 {
+	var crc uint32
+	for _, p := range parts {
+		crc = crc32.Update(crc, castagnoli, p)
+	}
+	return crc
+}
@@ -1,16 +1,67 @@
 package tcp
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
 	"net"
 	"runtime"
 	"sync"
 	"time"
 )
 
+// Backoff 客户端断线重连策略
+type Backoff struct {
+	Initial     time.Duration // 首次重连前的等待时间，默认500ms
+	Max         time.Duration // 最大等待时间，默认30s
+	Multiplier  float64       // 每次失败后等待时间的增长倍数，默认2
+	Jitter      float64       // 随机抖动比例，取值0~1
+	MaxAttempts int           // 连续重连失败的最大次数，0表示不限
+}
+
+// delay 计算第n次(从0开始)重连前的等待时间
+func (b *Backoff) delay(n int) time.Duration {
+	initial, max, multiplier := b.Initial, b.Max, b.Multiplier
+	if initial <= 0 {
+		initial = 500 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(initial) * math.Pow(multiplier, float64(n))
+	if d > float64(max) {
+		d = float64(max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// pendingWrite 断线期间缓存的待发送消息
+type pendingWrite struct {
+	msgID   int32
+	headers []byte
+	body    []byte
+}
+
 type Client struct {
-	conn    *net.TCPConn
-	session *Session
+	reconnect *Backoff
+	dialer    func(ctx context.Context, addr string) (net.Conn, error)
+
+	mu        sync.Mutex
+	session   *Session
+	quit      chan struct{}
+	pending   []pendingWrite
+	queueSize int
+	draining  bool // 正在发送断线期间缓存的消息，期间Write继续缓存以保证顺序
 
 	connBase
 }
@@ -24,73 +75,314 @@ func NewClient() *Client {
 	return c
 }
 
+// SetReconnect 开启断线重连，每次连接成功和断开都会触发SetOnConnected和SetOnDisconnect设置的回调
+func (c *Client) SetReconnect(b Backoff) {
+	c.reconnect = &b
+}
+
+// SetPendingQueue 设置断线期间Write最多缓存的消息数，重连成功后按顺序发送
+func (c *Client) SetPendingQueue(n int) {
+	c.queueSize = n
+}
+
+// SetDialer 设置建立连接的方法，默认使用TCP，可替换为Unix domain socket等其他传输方式
+func (c *Client) SetDialer(dial func(ctx context.Context, addr string) (net.Conn, error)) {
+	c.dialer = dial
+}
+
+// Connect 连接服务端并阻塞直到连接断开
+// 开启断线重连后，直到调用Close、收到退出信号或重连次数达到上限才返回
 func (c *Client) Connect(addr string, router *Router) error {
-	var err error
-	c.addr, err = net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		return err
+	dial := func() (net.Conn, error) {
+		if c.dialer != nil {
+			return c.dialer(context.Background(), addr)
+		}
+		return net.Dial("tcp", addr)
 	}
-	c.conn, err = net.DialTCP("tcp", nil, c.addr)
-	if err != nil {
-		return err
+	return c.run(router, addr, dial, c.reconnect)
+}
+
+// ConnectConn 在已建立的连接上运行客户端，阻塞直到连接断开，不支持断线重连
+func (c *Client) ConnectConn(conn net.Conn, router *Router) error {
+	dial := func() (net.Conn, error) {
+		if conn == nil {
+			return nil, net.ErrClosed
+		}
+		defer func() { conn = nil }()
+		return conn, nil
 	}
+	return c.run(router, conn.RemoteAddr().String(), dial, nil)
+}
 
-	c.session = NewSession(c.conn)
-	defer func() {
-		_ = c.session.Close()
-		c.session = nil
-	}()
+func (c *Client) run(router *Router, addr string, dial func() (net.Conn, error), reconnect *Backoff) error {
+	c.router = router
+	c.mu.Lock()
+	c.quit = make(chan struct{})
+	quit := c.quit
+	c.mu.Unlock()
 
 	c.state = StateRunning
 	defer func() {
-		c.wg.Wait()
 		c.state = StateTerminate
 	}()
 
 	// 处理信号
-	c.stopChan = make(chan error)
-	defer close(c.stopChan)
+	c.stopChan = make(chan error, 1)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go c.handleSignals(ctx)
 
+	var attempts int
+	for {
+		connected, err := c.connectOnce(addr, dial)
+		if connected {
+			attempts = 0
+		}
+		if reconnect == nil || errors.Is(err, errTerminal) {
+			c.beforeShutdown()
+			return err
+		}
+		if reconnect.MaxAttempts > 0 && attempts >= reconnect.MaxAttempts {
+			c.beforeShutdown()
+			return err
+		}
+
+		t := time.NewTimer(reconnect.delay(attempts))
+		attempts++
+		select {
+		case <-t.C:
+		case <-quit:
+			t.Stop()
+			c.beforeShutdown()
+			return err
+		case err = <-c.stopChan:
+			t.Stop()
+			c.beforeShutdown()
+			return err
+		}
+	}
+}
+
+// connectOnce 建立一次连接并阻塞直到连接断开
+func (c *Client) connectOnce(addr string, dial func() (net.Conn, error)) (bool, error) {
+	select {
+	case <-c.quit:
+		return false, net.ErrClosed
+	default:
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return false, err
+	}
+	c.addr = conn.RemoteAddr()
+
 	// 配置连接
-	err = c.configureConnection(c.conn)
+	err = c.configureConnection(conn)
 	if err != nil {
-		return err
+		_ = conn.Close()
+		return false, err
+	}
+
+	if c.tlsConfig != nil {
+		tlsConn := tls.Client(conn, c.clientTLSConfig(addr))
+		err = c.handshake(tlsConn)
+		if err != nil {
+			_ = conn.Close()
+			return false, err
+		}
+		conn = tlsConn
+	}
+	session := c.newSession(conn)
+	draining := c.attach(session)
+	select {
+	case <-c.quit:
+		// 连接建立期间调用了Close
+		_ = session.Close()
+	default:
 	}
 
 	// 读取消息
-	c.router = router
-	go c.readHandler(ctx, c.session)
+	readErr := make(chan error, 1)
+	c.wg.Add(1)
+	go c.readHandler(session, readErr)
+
+	// 发送断线期间缓存的消息，不持有锁，慢速的对端不会阻塞Close
+	if draining {
+		c.wg.Add(1)
+		go c.flushPending(session)
+	}
 
 	// 连接成功处理
-	go c.onConnected(NewContext(c.session, nil))
+	go c.onConnected(c.newContext(session, nil))
 
-	err = <-c.stopChan
-	c.beforeShutdown()
-	c.onDisconnected(c.session, err)
-	return err
+	select {
+	case err = <-readErr:
+	case err = <-c.stopChan:
+	}
+	c.detach()
+	_ = session.Close()
+	c.wg.Wait()
+	if cause := session.closeCause(); cause != nil {
+		err = cause
+	}
+	c.onDisconnected(session, err)
+	return true, err
 }
 
-func (c *Client) readHandler(ctx context.Context, session *Session) {
-	c.wg.Add(1)
+// clientTLSConfig 未设置ServerName时使用连接地址中的主机名校验服务端证书
+func (c *Client) clientTLSConfig(addr string) *tls.Config {
+	if c.tlsConfig.ServerName != "" || c.tlsConfig.InsecureSkipVerify {
+		return c.tlsConfig
+	}
+	config := c.tlsConfig.Clone()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	config.ServerName = host
+	return config
+}
+
+// attach 设置当前会话，有断线期间缓存的消息时返回true，由flushPending发送
+func (c *Client) attach(session *Session) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = session
+	c.draining = len(c.pending) > 0
+	return c.draining
+}
+
+// flushPending 按顺序发送断线期间缓存的消息，发送失败时剩余的消息留到下次连接
+func (c *Client) flushPending(session *Session) {
 	defer c.wg.Done()
+	for {
+		c.mu.Lock()
+		pending := c.pending
+		c.pending = nil
+		if len(pending) == 0 {
+			c.draining = false
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
 
-	var exited bool
-	go func() {
-		for {
-			msg, err := ReadMsg(session)
+		for i, w := range pending {
+			err := WriteMsg(session, w.msgID, w.headers, w.body)
 			if err != nil {
-				if exited {
-					return
-				}
-				c.stopChan <- err
+				c.mu.Lock()
+				c.pending = append(pending[i:], c.pending...)
+				c.draining = false
+				c.mu.Unlock()
 				return
 			}
-			c.onMessage(session, msg)
 		}
-	}()
-	<-ctx.Done()
-	exited = true
+	}
+}
+
+func (c *Client) detach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = nil
+	c.draining = false
+}
+
+// Session 返回当前会话，未连接时返回nil
+func (c *Client) Session() *Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// Close 关闭当前连接并停止重连
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.quit != nil {
+		select {
+		case <-c.quit:
+		default:
+			close(c.quit)
+		}
+	}
+	if c.session != nil {
+		return c.session.Close()
+	}
+	return nil
+}
+
+// Write 向服务端发送消息，可以在任意goroutine中并发调用
+// 未连接时，如果设置了SetPendingQueue则缓存消息等待重连后发送，否则返回ErrNotConnected；
+// 重连后缓存的消息发送完之前，新消息继续缓存，缓存已满时返回ErrQueueFull。
+// 缓存时会复制headers和body，Write返回后调用方可以继续复用
+func (c *Client) Write(msgID int32, headers, body []byte) error {
+	c.mu.Lock()
+	session := c.session
+	if session == nil || c.draining {
+		defer c.mu.Unlock()
+		if len(c.pending) >= c.queueSize {
+			if c.draining {
+				return ErrQueueFull
+			}
+			return ErrNotConnected
+		}
+		c.pending = append(c.pending, pendingWrite{msgID: msgID, headers: bytes.Clone(headers), body: bytes.Clone(body)})
+		return nil
+	}
+	c.mu.Unlock()
+	return WriteMsg(session, msgID, headers, body)
+}
+
+// Send 使用路由的默认编解码器编码并发送，未连接时的行为同Write
+func (c *Client) Send(msgID int32, v any) error {
+	codec := CodecJSON
+	if c.router != nil {
+		codec = c.router.codec
+	}
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Write(msgID, nil, b)
+}
+
+func (c *Client) SendJSON(msgID int32, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.Write(msgID, nil, b)
+}
+
+func (c *Client) SendText(msgID int32, data string) error {
+	return c.Write(msgID, nil, []byte(data))
+}
+
+func (c *Client) SendStream(msgID int32, headers interface{}, data []byte) error {
+	bHeader, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	return c.Write(msgID, bHeader, data)
+}
+
+func (c *Client) readHandler(session *Session, errChan chan<- error) {
+	defer c.wg.Done()
+	for {
+		msg, err := ReadMsg(session)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		c.onMessage(session, msg)
+	}
+}
+
+// Call 向服务端发送请求并等待响应，未连接时返回ErrSessionClosed
+func (c *Client) Call(ctx context.Context, msgID int32, body []byte) (*Message, error) {
+	session := c.Session()
+	if session == nil {
+		return nil, ErrSessionClosed
+	}
+	return session.Call(ctx, msgID, body)
 }
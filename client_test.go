@@ -0,0 +1,275 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectConnPipe(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	got := make(chan string, 1)
+	r := NewRouter()
+	r.Register(1, func(c *Context) {
+		got <- c.Text()
+	})
+
+	a := NewClient()
+	go a.ConnectConn(p1, r)
+
+	b := NewClient()
+	b.SetOnConnected(func(c *Context) {
+		_ = c.SendText(1, "hello")
+	})
+	go b.ConnectConn(p2, NewRouter())
+
+	select {
+	case text := <-got:
+		if text != "hello" {
+			t.Fatalf("got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	_ = a.Close()
+	_ = b.Close()
+}
+
+func TestSendClosed(t *testing.T) {
+	c := NewClient()
+	if err := c.SendText(1, "hello"); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("got %v, want ErrNotConnected", err)
+	}
+
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	session := NewSession(p1)
+	_ = session.Close()
+	if err := session.SendText(1, "hello"); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("got %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestClosePendingFlushSlowPeer(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+
+	// 对端不读取，缓存的消息发送阻塞
+	c := NewClient()
+	c.SetPendingQueue(4)
+	for range 3 {
+		if err := c.SendText(1, "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.ConnectConn(p1, NewRouter()) }()
+
+	deadline := time.Now().Add(time.Second)
+	for c.Session() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("not connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// 发送缓存消息期间新消息继续缓存
+	if err := c.SendText(1, "world"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by pending flush")
+	}
+}
+
+func TestWritePendingCopy(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	got := make(chan string, 1)
+	r := NewRouter()
+	r.Register(1, func(c *Context) {
+		got <- c.Text()
+	})
+	b := NewClient()
+	go b.ConnectConn(p2, r)
+	defer b.Close()
+
+	// 缓存后修改原切片不影响重连后发送的内容
+	a := NewClient()
+	a.SetPendingQueue(1)
+	buf := []byte("hello")
+	if err := a.Write(1, nil, buf); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf, "world")
+	go a.ConnectConn(p1, NewRouter())
+	defer a.Close()
+
+	select {
+	case text := <-got:
+		if text != "hello" {
+			t.Fatalf("got %q, want %q", text, "hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	for n, want := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		if got := b.delay(n); got != want {
+			t.Fatalf("delay(%d) = %v, want %v", n, got, want)
+		}
+	}
+
+	// 默认值
+	var d Backoff
+	if got := d.delay(0); got != 500*time.Millisecond {
+		t.Fatalf("default delay(0) = %v", got)
+	}
+	if got := d.delay(100); got != 30*time.Second {
+		t.Fatalf("default delay(100) = %v", got)
+	}
+
+	b.Jitter = 0.5
+	for range 100 {
+		if got := b.delay(1); got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("jitter delay(1) = %v", got)
+		}
+	}
+}
+
+func TestReconnectMaxAttempts(t *testing.T) {
+	errDial := errors.New("dial failed")
+	var dials []time.Time
+	c := NewClient()
+	c.SetReconnect(Backoff{Initial: 20 * time.Millisecond, Multiplier: 2, MaxAttempts: 3})
+	c.SetDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		dials = append(dials, time.Now())
+		return nil, errDial
+	})
+
+	err := c.Connect("127.0.0.1:0", NewRouter())
+	if !errors.Is(err, errDial) {
+		t.Fatalf("got %v", err)
+	}
+	// 首次连接加3次重连
+	if len(dials) != 4 {
+		t.Fatalf("dialed %d times, want 4", len(dials))
+	}
+	for i := 1; i < len(dials); i++ {
+		want := 20 * time.Millisecond << (i - 1)
+		if gap := dials[i].Sub(dials[i-1]); gap < want {
+			t.Fatalf("attempt %d after %v, want at least %v", i, gap, want)
+		}
+	}
+}
+
+// waitNewSession 等待服务端出现id大于last的会话
+func waitNewSession(t *testing.T, s *Server, last uint64) *Session {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, session := range s.Sessions() {
+			if session.ID() > last {
+				return session
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no new session")
+	return nil
+}
+
+func TestReconnect(t *testing.T) {
+	got := make(chan string, 10)
+	r := NewRouter()
+	r.Register(1, func(c *Context) {
+		got <- c.Text()
+	})
+	s := NewServer()
+	s.SetOrderedWorker(1)
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(r)
+	defer s.Shutdown(context.Background())
+
+	connected := make(chan struct{}, 10)
+	disconnected := make(chan error, 10)
+	c := NewClient()
+	c.SetReconnect(Backoff{Initial: 10 * time.Millisecond})
+	c.SetPendingQueue(10)
+	c.SetOnConnected(func(*Context) { connected <- struct{}{} })
+	c.SetOnDisconnect(func(_ *Session, err error) { disconnected <- err })
+
+	// 连接前写入的消息在连接成功后按顺序发送
+	for _, text := range []string{"1", "2", "3"} {
+		if err := c.SendText(1, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Connect(l.Addr().String(), NewRouter()) }()
+
+	wait := func(ch <-chan struct{}) {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case text := <-got:
+			if text != want {
+				t.Fatalf("got %q, want %q", text, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	// 每次断开和重连都触发回调
+	var kicked uint64
+	for range 2 {
+		wait(connected)
+		session := waitNewSession(t, s, kicked)
+		kicked = session.ID()
+		if err := s.Kick(kicked, "test"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	wait(connected)
+
+	// Close停止重连
+	_ = c.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not stop reconnecting")
+	}
+	select {
+	case <-connected:
+		t.Fatal("reconnected after Close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,121 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec 消息体编解码器，用于Context.Bind和Context.Send
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// 内置编解码器
+var (
+	CodecJSON Codec = jsonCodec{}
+	CodecGob  Codec = gobCodec{}
+	CodecRaw  Codec = rawCodec{}
+)
+
+var (
+	codecMu sync.RWMutex
+	codecs  = map[string]Codec{
+		CodecJSON.Name(): CodecJSON,
+		CodecGob.Name():  CodecGob,
+		CodecRaw.Name():  CodecRaw,
+	}
+)
+
+// RegisterCodec 注册编解码器，同名的编解码器会被替换，可用于接入protobuf、msgpack等
+func RegisterCodec(codec Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+// GetCodec 根据名称获取已注册的编解码器
+func GetCodec(name string) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	codec, ok := codecs[name]
+	return codec, ok
+}
+
+// json
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// gob
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// raw 不做编码，支持[]byte和string
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch data := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return data, nil
+	case *[]byte:
+		return *data, nil
+	case string:
+		return []byte(data), nil
+	case *string:
+		return []byte(*data), nil
+	default:
+		return nil, fmt.Errorf("raw codec: unsupported type %T", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch p := v.(type) {
+	case *[]byte:
+		*p = append((*p)[:0], data...)
+	case *string:
+		*p = string(data)
+	default:
+		return fmt.Errorf("raw codec: unsupported type %T", v)
+	}
+	return nil
+}
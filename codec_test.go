@@ -0,0 +1,159 @@
+package tcp
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecPayload struct {
+	Name  string
+	Count int
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	in := codecPayload{Name: "gotcp", Count: 3}
+	for _, codec := range []Codec{CodecJSON, CodecGob} {
+		b, err := codec.Marshal(in)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		var out codecPayload
+		if err := codec.Unmarshal(b, &out); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		if out != in {
+			t.Fatalf("%s: got %+v", codec.Name(), out)
+		}
+	}
+
+	// raw支持[]byte和string
+	b, err := CodecRaw.Marshal("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := CodecRaw.Unmarshal(b, &s); err != nil || s != "text" {
+		t.Fatalf("got %q, %v", s, err)
+	}
+	b, err = CodecRaw.Marshal([]byte("bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []byte
+	if err := CodecRaw.Unmarshal(b, &raw); err != nil || string(raw) != "bytes" {
+		t.Fatalf("got %q, %v", raw, err)
+	}
+	if _, err := CodecRaw.Marshal(in); err == nil {
+		t.Fatal("raw codec accepted a struct")
+	}
+	if err := CodecRaw.Unmarshal(b, &in); err == nil {
+		t.Fatal("raw codec accepted a struct")
+	}
+}
+
+// upperCodec 测试用的自定义编解码器
+type upperCodec struct{}
+
+func (upperCodec) Name() string {
+	return "upper"
+}
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	return bytes.ToUpper([]byte(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	*v.(*string) = string(bytes.ToLower(data))
+	return nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	for _, name := range []string{"json", "gob", "raw"} {
+		if _, ok := GetCodec(name); !ok {
+			t.Fatalf("codec %s not registered", name)
+		}
+	}
+	RegisterCodec(upperCodec{})
+	codec, ok := GetCodec("upper")
+	if !ok || codec.Name() != "upper" {
+		t.Fatalf("got %v, %v", codec, ok)
+	}
+	b, err := codec.Marshal("hello")
+	if err != nil || string(b) != "HELLO" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	var s string
+	if err := codec.Unmarshal(b, &s); err != nil || s != "hello" {
+		t.Fatalf("got %q, %v", s, err)
+	}
+}
+
+func TestRouteCodec(t *testing.T) {
+	r := NewRouter()
+	r.SetCodec(CodecGob)
+	r.SetRouteCodec(2, CodecRaw)
+	if r.GetCodec(1) != CodecGob || r.GetCodec(2) != CodecRaw {
+		t.Fatalf("got %s, %s", r.GetCodec(1).Name(), r.GetCodec(2).Name())
+	}
+
+	got := make(chan any, 2)
+	r.Register(1, func(c *Context) {
+		var v codecPayload
+		if err := c.Bind(&v); err != nil {
+			got <- err
+			return
+		}
+		got <- v
+		// 响应使用同一个编解码器
+		_ = c.Send(3, v)
+	})
+	r.Register(2, func(c *Context) {
+		var v []byte
+		if err := c.Bind(&v); err != nil {
+			got <- err
+			return
+		}
+		got <- string(v)
+	})
+
+	p1, p2 := net.Pipe()
+	a := NewClient()
+	go a.ConnectConn(p1, r)
+	defer a.Close()
+	peer := NewSession(p2)
+	defer peer.Close()
+
+	in := codecPayload{Name: "gob", Count: 1}
+	b, err := CodecGob.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMsg(peer, 1, nil, b); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ReadMsg(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out codecPayload
+	if err := CodecGob.Unmarshal(msg.Body(), &out); err != nil || out != in {
+		t.Fatalf("reply %+v, %v", out, err)
+	}
+	if err := WriteMsg(peer, 2, nil, []byte("raw")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []any{in, "raw"} {
+		select {
+		case v := <-got:
+			if !reflect.DeepEqual(v, want) {
+				t.Fatalf("got %v, want %v", v, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
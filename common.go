@@ -1,8 +1,10 @@
 package tcp
 
 import (
+	"bufio"
 	"context"
-	"errors"
+	"crypto/tls"
+	"encoding/binary"
 	"net"
 	"os"
 	"os/signal"
@@ -23,17 +25,50 @@ const (
 
 type connBase struct {
 	// addr 服务端监听地址 或 客户端连接地址
-	addr *net.TCPAddr
+	addr net.Addr
 
 	// wg 用于等待所有goroutine退出
 	wg sync.WaitGroup
 
-	state      uint8
-	stopChan   chan error
-	showLog    bool // 是否打印日志
-	workerNum  int
-	worker     *worker.Worker
-	hbInterval time.Duration
+	state     uint8
+	stopChan  chan error
+	showLog   bool // 是否打印日志
+	workerNum int
+	worker    *worker.Worker
+	ordered   *worker.Ordered // 不为nil时同一会话的消息按到达顺序执行
+
+	// worker关闭后不再接收新任务，dispatchQuit关闭时等待入队的任务立即返回
+	dispatchMu   sync.RWMutex
+	dispatchQuit chan struct{}
+	hbInterval   time.Duration
+
+	// 应用层心跳和空闲超时
+	pingInterval time.Duration
+	pingMaxMiss  int
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// 异步发送队列
+	queueSize   int
+	queuePolicy OverflowPolicy
+	queueBatch  int
+
+	readBufferSize int
+	messagePool    bool
+
+	// 帧格式，0值使用默认配置
+	maxMsgSize    int
+	maxHeaderSize int
+	byteOrder     binary.ByteOrder
+	checksum      bool
+
+	// 压缩
+	compression       Compression
+	compressThreshold int
+	maxDecompressed   int
+
+	tlsConfig        *tls.Config
+	handshakeTimeout time.Duration
 
 	router *Router
 
@@ -42,9 +77,215 @@ type connBase struct {
 	beforeShutdownHandler func()
 }
 
+// SetWorker 设置worker数量，消息在共享的worker池中并发执行，不保证同一会话的消息顺序
 func (b *connBase) SetWorker(w int) {
 	b.workerNum = w
 	b.worker = worker.NewWorker(b.workerNum, b.workerNum*2)
+	b.ordered = nil
+	b.dispatchQuit = make(chan struct{})
+}
+
+// SetOrderedWorker 设置保序worker数量，同一会话的消息严格按到达顺序串行执行，
+// 不同会话的消息仍然并行执行
+func (b *connBase) SetOrderedWorker(w int) {
+	b.workerNum = w
+	b.ordered = worker.NewOrdered(b.workerNum, b.workerNum*2)
+	b.worker = nil
+	b.dispatchQuit = make(chan struct{})
+}
+
+// dispatch 将任务交给worker执行，worker已关闭时返回false
+// 队列已满时阻塞等待，worker开始关闭时立即返回，不会阻塞shutdownWorker
+func (b *connBase) dispatch(session *Session, job func()) bool {
+	b.dispatchMu.RLock()
+	defer b.dispatchMu.RUnlock()
+	select {
+	case <-b.dispatchQuit:
+		return false
+	default:
+	}
+	if b.ordered != nil {
+		return b.ordered.StartJobUntil(session.id, job, b.dispatchQuit)
+	}
+	return b.worker.StartJobUntil(job, b.dispatchQuit)
+}
+
+// shutdownWorker 关闭worker，返回的chan在所有任务执行完后关闭
+func (b *connBase) shutdownWorker() chan struct{} {
+	// 先通知阻塞在dispatch中的goroutine返回并释放读锁
+	close(b.dispatchQuit)
+	b.dispatchMu.Lock()
+	defer b.dispatchMu.Unlock()
+	if b.ordered != nil {
+		return b.ordered.Shutdown()
+	}
+	return b.worker.Shutdown()
+}
+
+// renewWorker 重新创建已关闭的worker
+func (b *connBase) renewWorker() {
+	b.dispatchMu.Lock()
+	defer b.dispatchMu.Unlock()
+	select {
+	case <-b.dispatchQuit:
+	default:
+		return
+	}
+	b.dispatchQuit = make(chan struct{})
+	if b.ordered != nil {
+		b.ordered = worker.NewOrdered(b.workerNum, b.workerNum*2)
+		return
+	}
+	b.worker = worker.NewWorker(b.workerNum, b.workerNum*2)
+}
+
+// SetHeartbeat 开启应用层心跳，每隔interval发送一次Ping，
+// 连续maxMiss个间隔未收到对端任何消息时关闭连接，SetOnDisconnect收到ErrHeartbeatTimeout
+func (b *connBase) SetHeartbeat(interval time.Duration, maxMiss int) {
+	if maxMiss <= 0 {
+		maxMiss = 3
+	}
+	b.pingInterval = interval
+	b.pingMaxMiss = maxMiss
+}
+
+// SetIdleTimeout 设置空闲超时，0表示不限制
+// read: 超过该时间未收到消息时关闭连接，SetOnDisconnect收到ErrReadTimeout
+// write: 单条消息超过该时间未发送完成时关闭连接，SetOnDisconnect收到ErrWriteTimeout
+func (b *connBase) SetIdleTimeout(read, write time.Duration) {
+	b.readTimeout = read
+	b.writeTimeout = write
+}
+
+// SetWriteQueue 为每个连接开启异步发送队列，由单独的goroutine写入连接，慢速的对端不会阻塞发送者
+// capacity: 队列容量，0表示关闭队列(同步发送)；policy: 队列满时的处理策略。
+// 开启后WriteMsg等发送方法在消息入队后即返回，写入失败时关闭连接，消息体在发送完成前不能被修改
+func (b *connBase) SetWriteQueue(capacity int, policy OverflowPolicy) {
+	b.queueSize = capacity
+	b.queuePolicy = policy
+}
+
+// SetWriteCoalescing 开启合并写入，发送队列中有多条消息时最多合并maxFrames条为一次系统调用，
+// 需要同时通过SetWriteQueue开启发送队列
+func (b *connBase) SetWriteCoalescing(maxFrames int) {
+	b.queueBatch = maxFrames
+}
+
+// SetReadBufferSize 设置每个连接的读缓冲区大小，默认4KB，
+// 小消息较多时多条消息可以通过一次系统调用读入
+func (b *connBase) SetReadBufferSize(n int) {
+	b.readBufferSize = n
+}
+
+// SetMessagePool 开启后收到消息的header和body从对象池分配，处理链执行完后归还，减少GC压力
+// 开启后处理函数返回后不能再访问消息内容(包括Header、Body、Reader返回的数据)，
+// 需要在返回后继续使用时调用Context.Retain并在使用完后调用Message.Release，或者使用Message.Clone拷贝
+func (b *connBase) SetMessagePool(enable bool) {
+	b.messagePool = enable
+}
+
+// SetMaxMsgSize 设置最大帧长度(包括帧头)，默认MaxMsgSize，
+// 发送和接收超过限制的消息时返回ErrMessageTooLarge，接收时同时关闭连接
+func (b *connBase) SetMaxMsgSize(n int) {
+	b.maxMsgSize = n
+}
+
+// SetMaxHeaderSize 设置最大head长度，不能超过0xFFFFFF，默认不限制
+func (b *connBase) SetMaxHeaderSize(n int) {
+	b.maxHeaderSize = min(n, maxHeadLength)
+}
+
+// SetByteOrder 设置帧中整数字段的字节序，默认binary.LittleEndian，通信双方必须一致
+func (b *connBase) SetByteOrder(order binary.ByteOrder) {
+	b.byteOrder = order
+}
+
+// SetCompression 设置发送时使用的压缩算法，消息体长度达到threshold时压缩，threshold<=0时使用默认值1KB
+// 每个帧通过标志位标明压缩算法，接收端自动解压，处理函数看到的是解压后的消息体
+func (b *connBase) SetCompression(c Compression, threshold int) {
+	if threshold <= 0 {
+		threshold = defaultCompressThreshold
+	}
+	b.compression = c
+	b.compressThreshold = threshold
+}
+
+// SetMaxDecompressedSize 设置解压后消息体的最大长度，默认与最大帧长度相同，
+// 超过时关闭连接，SetOnDisconnect收到ErrMessageTooLarge
+func (b *connBase) SetMaxDecompressedSize(n int) {
+	b.maxDecompressed = n
+}
+
+// SetChecksum 开启帧校验，发送时在帧末尾添加CRC32C校验和，接收时要求对端必须带校验和，
+// 校验失败时关闭连接，SetOnDisconnect收到ErrChecksum。通信双方需要同时开启
+func (b *connBase) SetChecksum(enable bool) {
+	b.checksum = enable
+}
+
+// newSession 创建会话并应用连接配置
+func (b *connBase) newSession(conn net.Conn) *Session {
+	session := NewSession(conn)
+	if b.readBufferSize > 0 {
+		session.reader = bufio.NewReaderSize(conn, b.readBufferSize)
+	}
+	session.pooled = b.messagePool
+	session.checksum = b.checksum
+	if b.maxMsgSize > 0 {
+		session.framing.maxMsgSize = uint64(b.maxMsgSize)
+	}
+	if b.maxHeaderSize > 0 {
+		session.framing.maxHeaderSize = uint64(b.maxHeaderSize)
+	}
+	if b.byteOrder != nil {
+		session.framing.order = b.byteOrder
+	}
+	session.framing.compression = b.compression
+	session.framing.compressThreshold = b.compressThreshold
+	if b.maxDecompressed > 0 {
+		session.framing.maxDecompressed = uint64(b.maxDecompressed)
+	}
+	session.readTimeout = b.readTimeout
+	session.writeTimeout = b.writeTimeout
+	if b.router != nil {
+		session.codec = b.router.codec
+	}
+	if b.queueSize > 0 {
+		session.startWriter(b.queueSize, b.queuePolicy, b.queueBatch)
+	}
+	if b.pingInterval > 0 {
+		go b.heartbeat(session)
+	}
+	return session
+}
+
+// heartbeat 定时发送Ping，超时未收到消息时关闭会话
+func (b *connBase) heartbeat(session *Session) {
+	ticker := time.NewTicker(b.pingInterval)
+	defer ticker.Stop()
+	timeout := b.pingInterval * time.Duration(b.pingMaxMiss)
+	for {
+		select {
+		case <-session.closeChan:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, session.lastRead.Load())) > timeout {
+				_ = session.CloseWithError(ErrHeartbeatTimeout)
+				return
+			}
+			_ = WriteMsg(session, MsgIDPing, nil, nil)
+		}
+	}
+}
+
+// SetTLSConfig 启用TLS，服务端设置ClientAuth和ClientCAs即可开启双向认证，
+// 客户端通过Certificates提供客户端证书
+func (b *connBase) SetTLSConfig(config *tls.Config) {
+	b.tlsConfig = config
+}
+
+// SetHandshakeTimeout 设置TLS握手超时时间，默认10秒
+func (b *connBase) SetHandshakeTimeout(d time.Duration) {
+	b.handshakeTimeout = d
 }
 
 func (b *connBase) SetOnConnected(f func(c *Context)) {
@@ -59,6 +300,19 @@ func (b *connBase) SetBeforeShutdown(f func()) {
 	b.beforeShutdownHandler = f
 }
 
+// newContext 创建Context并设置路由配置的编解码器
+func (b *connBase) newContext(session *Session, msg *Message) *Context {
+	c := NewContext(session, msg)
+	if b.router != nil {
+		if msg != nil {
+			c.codec = b.router.GetCodec(msg.ID())
+		} else {
+			c.codec = b.router.codec
+		}
+	}
+	return c
+}
+
 func (b *connBase) onConnected(ctx *Context) {
 	if b.connectedHandler != nil {
 		b.connectedHandler(ctx)
@@ -77,30 +331,77 @@ func (b *connBase) beforeShutdown() {
 	}
 }
 
-func (b *connBase) configureConnection(conn *net.TCPConn) error {
-	err := conn.SetKeepAlive(true)
+// keepAliveConn 支持TCP keepalive的连接
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// configureConnection 配置连接参数，TCP keepalive仅对支持的连接生效
+func (b *connBase) configureConnection(conn net.Conn) error {
+	kc, ok := conn.(keepAliveConn)
+	if !ok {
+		return nil
+	}
+	err := kc.SetKeepAlive(true)
 	if err != nil {
 		return err
 	}
-	return conn.SetKeepAlivePeriod(b.hbInterval)
+	return kc.SetKeepAlivePeriod(b.hbInterval)
 }
 
-func (b *connBase) onMessage(session *Session, msg *Message) {
-	b.worker.StartJob(func() {
-		c := NewContext(session, msg)
+// handshake 完成TLS握手
+func (b *connBase) handshake(conn *tls.Conn) error {
+	timeout := b.handshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return conn.HandshakeContext(ctx)
+}
 
-		handlers := b.router.GetHandlers(c.MsgID())
-		if len(handlers) == 0 {
-			logger.Warnf("No handler for message id: %d", c.MsgID())
+func (b *connBase) onMessage(session *Session, msg *Message) {
+	// 心跳消息
+	switch msg.id {
+	case MsgIDPing:
+		msg.Release()
+		_ = WriteMsg(session, MsgIDPong, nil, nil)
+		return
+	case MsgIDPong:
+		msg.Release()
+		return
+	}
+	// Call的响应直接交给等待者，不经过路由，响应的生命周期由调用者决定，不归还对象池
+	if msg.flags&flagReply != 0 {
+		msg.detach()
+		if !session.resolveCall(msg) {
+			logger.Debugf("No pending call for reply: %d", msg.seq)
+		}
+		return
+	}
+	// 未注册消息计数
+	if b.router.maxUnknown > 0 && !b.router.hasRoute(msg.id) {
+		if session.unknown.Add(1) > int64(b.router.maxUnknown) {
+			logger.Warnw("Too many unknown messages", "remote", session.Remote(), "msgId", msg.id)
+			msg.Release()
+			_ = session.CloseWithError(ErrTooManyUnknown)
 			return
 		}
-		c.handlers = append(b.router.GetMiddlewares(), handlers...)
-		// 执行消息处理函数
-		for c.index < int8(len(handlers)) {
-			c.handlers[c.index](c)
-			c.index++
+	}
+
+	ok := b.dispatch(session, func() {
+		// 处理链执行完后归还消息缓冲区，Context.Retain可以延长
+		defer msg.Release()
+		c := b.newContext(session, msg)
+		if !b.router.handle(c) {
+			logger.Warnf("No handler for message id: %d", c.MsgID())
 		}
 	})
+	if !ok {
+		msg.Release()
+		logger.Debugf("Worker closed, drop message id: %d", msg.ID())
+	}
 }
 
 func (b *connBase) terminal() {
@@ -108,7 +409,7 @@ func (b *connBase) terminal() {
 		return
 	}
 	b.state = StateShuttingDown
-	b.stopChan <- errors.New("terminal")
+	b.stopChan <- errTerminal
 }
 
 func (b *connBase) handleSignals(ctx context.Context) {
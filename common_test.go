@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveDisconnect 启动服务端，返回监听地址和SetOnDisconnect收到的错误
+func serveDisconnect(t *testing.T, s *Server) (string, <-chan error) {
+	disconnected := make(chan error, 1)
+	s.SetOnDisconnect(func(_ *Session, err error) {
+		disconnected <- err
+	})
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(NewRouter())
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	return l.Addr().String(), disconnected
+}
+
+func waitDisconnect(t *testing.T, disconnected <-chan error, want error) {
+	select {
+	case err := <-disconnected:
+		if !errors.Is(err, want) {
+			t.Fatalf("got %v, want %v", err, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	s := NewServer()
+	s.SetHeartbeat(30*time.Millisecond, 5)
+	addr, disconnected := serveDisconnect(t, s)
+
+	// 客户端自动响应Ping，连接保持
+	c := NewClient()
+	go c.Connect(addr, NewRouter())
+	defer c.Close()
+	select {
+	case err := <-disconnected:
+		t.Fatalf("disconnected: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	_ = c.Close()
+	<-disconnected
+
+	// 不响应Ping的连接在连续丢失5次心跳后被关闭
+	start := time.Now()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitDisconnect(t, disconnected, ErrHeartbeatTimeout)
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("closed after %v, want at least 5 intervals", d)
+	}
+}
+
+func TestReadIdleTimeout(t *testing.T) {
+	s := NewServer()
+	s.SetIdleTimeout(50*time.Millisecond, 0)
+	addr, disconnected := serveDisconnect(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitDisconnect(t, disconnected, ErrReadTimeout)
+}
+
+func TestWriteIdleTimeout(t *testing.T) {
+	s := NewServer()
+	s.SetIdleTimeout(0, 50*time.Millisecond)
+	s.SetOnConnected(func(c *Context) {
+		_ = c.Session().Conn().(*net.TCPConn).SetWriteBuffer(16 * 1024)
+		body := make([]byte, 64*1024)
+		for WriteMsg(c.Session(), 1, nil, body) == nil {
+		}
+	})
+	addr, disconnected := serveDisconnect(t, s)
+
+	// 客户端不读取，服务端发送阻塞
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.(*net.TCPConn).SetReadBuffer(16 * 1024)
+	waitDisconnect(t, disconnected, ErrWriteTimeout)
+}
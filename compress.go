@@ -0,0 +1,120 @@
+package tcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression 消息体压缩算法，保存在帧标志位的第4、5位，
+// 接收端根据每个帧的标志位解压，支持所有内置算法，不需要与对端协商
+type Compression uint8
+
+const (
+	CompressNone  Compression = iota // 不压缩
+	CompressFlate                    // compress/flate
+	CompressGzip                     // compress/gzip
+	CompressZlib                     // compress/zlib
+)
+
+// 压缩算法在帧标志位中的位置
+const (
+	compressShift       = 4
+	compressMask  uint8 = 3 << compressShift
+)
+
+// defaultCompressThreshold 默认压缩阈值，小于该长度的消息体不压缩
+const defaultCompressThreshold = 1024
+
+// errUnknownCompression 帧标志位中的压缩算法无法识别
+var errUnknownCompression = errors.New("unknown compression")
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressFlate:
+		return "flate"
+	case CompressGzip:
+		return "gzip"
+	case CompressZlib:
+		return "zlib"
+	}
+	return fmt.Sprintf("Compression(%d)", uint8(c))
+}
+
+// compressWriter 可以复用的压缩writer
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// 压缩writer的初始化开销较大，按算法复用
+var compressWriters = [...]sync.Pool{
+	CompressFlate: {New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}},
+	CompressGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	CompressZlib: {New: func() any {
+		return zlib.NewWriter(nil)
+	}},
+}
+
+// compress 压缩消息体，压缩后没有变小时返回false
+func compress(c Compression, body []byte) ([]byte, bool) {
+	if c == CompressNone || int(c) >= len(compressWriters) {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(body) / 2)
+	w := compressWriters[c].Get().(compressWriter)
+	defer compressWriters[c].Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(body) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompress 解压消息体，解压后超过limit时返回ErrMessageTooLarge，防止压缩炸弹
+func decompress(c Compression, body []byte, limit uint64) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch c {
+	case CompressFlate:
+		r = flate.NewReader(bytes.NewReader(body))
+	case CompressGzip:
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case CompressZlib:
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, errUnknownCompression
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) > limit {
+		return nil, &MessageTooLargeError{Field: "decompressed body", Size: uint64(n), Limit: limit}
+	}
+	return buf.Bytes(), nil
+}
@@ -2,28 +2,47 @@ package tcp
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"io"
 	"math"
 	"net"
+	"sync"
+	"time"
 )
 
-const (
-	abortIndex = math.MaxInt8 >> 1
-)
+// abortIndex Abort后index设置为该值，处理链长度不能超过该值
+const abortIndex = math.MaxInt >> 1
 
+// Context 消息处理上下文，实现了context.Context，会话关闭时被取消，
+// 可以直接传给数据库等需要context.Context的调用
 type Context struct {
+	ctx     context.Context
 	session *Session
 	msg     *Message
+	codec   Codec
 
 	handlers []func(ctx *Context)
-	index    int8
+	index    int
+	errors   []error
+
+	// 当前消息的键值对，用于在中间件和处理函数之间传递数据
+	mu   sync.RWMutex
+	keys map[string]any
 }
 
 func NewContext(session *Session, msg *Message) *Context {
+	ctx := context.Background()
+	if session != nil {
+		ctx = session.Context()
+	}
 	return &Context{
+		ctx:     ctx,
 		session: session,
 		msg:     msg,
+		index:   -1,
 	}
 }
 
@@ -31,16 +50,54 @@ func (c *Context) Session() *Session {
 	return c.session
 }
 
-func (c *Context) Conn() *net.TCPConn {
+func (c *Context) Conn() net.Conn {
 	return c.session.Conn()
 }
 
+// TLSState 返回TLS连接状态，非TLS连接返回false
+func (c *Context) TLSState() (*tls.ConnectionState, bool) {
+	return c.session.TLSState()
+}
+
+// PeerCertificate 返回已通过验证的对端证书，用于识别对端身份
+func (c *Context) PeerCertificate() *x509.Certificate {
+	return c.session.PeerCertificate()
+}
+
 func (c *Context) Remote() string {
 	return c.session.Conn().RemoteAddr().String()
 }
 
 func (c *Context) Close() error {
-	return c.session.Conn().Close()
+	return c.session.Close()
+}
+
+// keys
+
+// Set 保存键值对，仅在当前消息的处理链中有效
+func (c *Context) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string]any)
+	}
+	c.keys[key] = value
+}
+
+// Get 获取Set保存的值
+func (c *Context) Get(key string) (value any, exists bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, exists = c.keys[key]
+	return
+}
+
+// MustGet 获取Set保存的值，不存在时panic
+func (c *Context) MustGet(key string) any {
+	if value, exists := c.Get(key); exists {
+		return value
+	}
+	panic("key \"" + key + "\" does not exist")
 }
 
 // msg
@@ -53,6 +110,16 @@ func (c *Context) MsgSize() uint32 {
 	return c.msg.size
 }
 
+// Retain 增加消息的引用计数并返回消息，开启SetMessagePool时处理函数返回后消息内容仍然有效，
+// 使用完后必须调用Message.Release；未开启时直接返回消息
+func (c *Context) Retain() *Message {
+	if c.msg == nil {
+		return nil
+	}
+	c.msg.retain()
+	return c.msg
+}
+
 // headers
 
 func (c *Context) HeaderBindJSON(v interface{}) error {
@@ -69,6 +136,11 @@ func (c *Context) BindJSON(v interface{}) error {
 	return json.Unmarshal(c.msg.body, v)
 }
 
+// Bind 使用路由配置的编解码器解析消息体
+func (c *Context) Bind(v any) error {
+	return c.Codec().Unmarshal(c.msg.body, v)
+}
+
 func (c *Context) Reader() io.Reader {
 	return bytes.NewReader(c.msg.body)
 }
@@ -77,18 +149,59 @@ func (c *Context) CopyTo(writer io.Writer) (int64, error) {
 	return io.Copy(writer, c.Reader())
 }
 
-// response
+// context.Context
+
+func (c *Context) Deadline() (deadline time.Time, ok bool) {
+	return c.ctx.Deadline()
+}
+
+func (c *Context) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+func (c *Context) Err() error {
+	return c.ctx.Err()
+}
+
+// Value 字符串类型的key优先查找Set保存的值
+func (c *Context) Value(key any) any {
+	if k, ok := key.(string); ok {
+		if value, exists := c.Get(k); exists {
+			return value
+		}
+	}
+	return c.ctx.Value(key)
+}
+
+// response，发送时遵循Context的截止时间
+
+// Codec 返回当前消息使用的编解码器，未配置时为CodecJSON
+func (c *Context) Codec() Codec {
+	if c.codec == nil {
+		return CodecJSON
+	}
+	return c.codec
+}
+
+// Send 使用当前消息的编解码器编码并发送
+func (c *Context) Send(msgID int32, v any) error {
+	b, err := c.Codec().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return WriteMsgWithContext(c, c.session, msgID, nil, b)
+}
 
 func (c *Context) SendJSON(msgID int32, data interface{}) error {
 	b, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	return WriteMsg(c.session, msgID, nil, b)
+	return WriteMsgWithContext(c, c.session, msgID, nil, b)
 }
 
 func (c *Context) SendText(msgID int32, data string) error {
-	return WriteMsg(c.session, msgID, nil, []byte(data))
+	return WriteMsgWithContext(c, c.session, msgID, nil, []byte(data))
 }
 
 func (c *Context) SendStream(msgID int32, headers interface{}, data []byte) error {
@@ -96,19 +209,64 @@ func (c *Context) SendStream(msgID int32, headers interface{}, data []byte) erro
 	if err != nil {
 		return err
 	}
-	return WriteMsg(c.session, msgID, bHeader, data)
+	return WriteMsgWithContext(c, c.session, msgID, bHeader, data)
+}
+
+// Reply 响应当前消息，如果当前消息是Call发起的请求则自动带上关联id
+func (c *Context) Reply(msgID int32, data []byte) error {
+	if c.msg == nil || c.msg.flags&flagRequest == 0 {
+		return WriteMsgWithContext(c, c.session, msgID, nil, data)
+	}
+	return writeMsg(c, c.session, msgID, flagReply, c.msg.seq, nil, data)
+}
+
+// SendError 发送标准错误响应，非*Error类型的错误使用CodeInternal，
+// 如果当前消息是Call发起的请求则自动带上关联id
+func (c *Context) SendError(err error) error {
+	b, err := json.Marshal(toError(err))
+	if err != nil {
+		return err
+	}
+	return c.Reply(MsgIDError, b)
 }
 
 // handler
 
+// Next 执行处理链中剩余的处理函数，只能在中间件中调用
+// 中间件不调用Next时，返回后继续执行下一个处理函数；
+// 调用Next时，Next返回后剩余的处理函数均已执行完，可用于在处理前后做统计等
 func (c *Context) Next() {
 	c.index++
-	for c.index < int8(len(c.handlers)) {
+	for c.index < len(c.handlers) {
 		c.handlers[c.index](c)
 		c.index++
 	}
 }
 
+// Abort 阻止执行处理链中剩余的处理函数，不会中断当前处理函数
 func (c *Context) Abort() {
 	c.index = abortIndex
 }
+
+// IsAborted 是否已调用Abort
+func (c *Context) IsAborted() bool {
+	return c.index >= abortIndex
+}
+
+// Error 记录处理过程中的错误，处理链执行完后交给Router.OnError处理，不会中断处理链
+func (c *Context) Error(err error) {
+	if err != nil {
+		c.errors = append(c.errors, err)
+	}
+}
+
+// AbortWithError 记录错误并调用Abort
+func (c *Context) AbortWithError(err error) {
+	c.Error(err)
+	c.Abort()
+}
+
+// Errors 返回处理过程中记录的错误
+func (c *Context) Errors() []error {
+	return c.errors
+}
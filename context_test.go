@@ -0,0 +1,266 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runChain 按onMessage的方式执行消息处理链
+func runChain(r *Router, msgId int32) *Context {
+	c := NewContext(nil, &Message{id: msgId})
+	r.handle(c)
+	return c
+}
+
+func record(trace *[]string, name string) func(c *Context) {
+	return func(c *Context) {
+		*trace = append(*trace, name)
+	}
+}
+
+func TestChainWithoutNext(t *testing.T) {
+	var trace []string
+	r := NewRouter()
+	r.Use(record(&trace, "m1"), record(&trace, "m2"))
+	r.Register(1, record(&trace, "h1"), record(&trace, "h2"))
+	r.Register(1, record(&trace, "h3"))
+
+	runChain(r, 1)
+	want := []string{"m1", "m2", "h1", "h2", "h3"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("got %v, want %v", trace, want)
+	}
+}
+
+func TestChainNext(t *testing.T) {
+	var trace []string
+	r := NewRouter()
+	r.Use(func(c *Context) {
+		trace = append(trace, "before")
+		c.Next()
+		trace = append(trace, "after")
+	})
+	r.Register(1, record(&trace, "h1"), record(&trace, "h2"))
+
+	runChain(r, 1)
+	want := []string{"before", "h1", "h2", "after"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("got %v, want %v", trace, want)
+	}
+}
+
+func TestChainAbort(t *testing.T) {
+	var trace []string
+	r := NewRouter()
+	r.OnError(nil)
+	r.Use(func(c *Context) {
+		c.Next()
+		if !c.IsAborted() {
+			t.Error("IsAborted = false after Abort")
+		}
+		trace = append(trace, "after")
+	})
+	r.Register(1, func(c *Context) {
+		trace = append(trace, "h1")
+		c.AbortWithError(errors.New("denied"))
+	}, record(&trace, "h2"))
+
+	c := runChain(r, 1)
+	want := []string{"h1", "after"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("got %v, want %v", trace, want)
+	}
+	if len(c.Errors()) != 1 || c.Errors()[0].Error() != "denied" {
+		t.Fatalf("got errors %v", c.Errors())
+	}
+}
+
+func TestChainLong(t *testing.T) {
+	var n int
+	r := NewRouter()
+	for range 300 {
+		r.Register(1, func(c *Context) { n++ })
+	}
+	runChain(r, 1)
+	if n != 300 {
+		t.Fatalf("got %d handlers executed, want 300", n)
+	}
+}
+
+func TestChainGroup(t *testing.T) {
+	var trace []string
+	r := NewRouter()
+	r.Use(record(&trace, "global"))
+	admin := r.Group(record(&trace, "admin"))
+	super := admin.Group(record(&trace, "super"))
+	admin.Register(1, record(&trace, "h1"))
+	super.Register(2, record(&trace, "h2"))
+	r.Register(3, record(&trace, "h3"))
+
+	tests := []struct {
+		msgId int32
+		want  []string
+	}{
+		{1, []string{"global", "admin", "h1"}},
+		{2, []string{"global", "admin", "super", "h2"}},
+		{3, []string{"global", "h3"}},
+	}
+	for _, tt := range tests {
+		trace = nil
+		runChain(r, tt.msgId)
+		if !reflect.DeepEqual(trace, tt.want) {
+			t.Errorf("msg %d: got %v, want %v", tt.msgId, trace, tt.want)
+		}
+	}
+}
+
+func TestChainNoRoute(t *testing.T) {
+	var trace []string
+	r := NewRouter()
+	r.Use(record(&trace, "global"))
+	r.Register(1, record(&trace, "h1"))
+
+	if chain := r.handlerChain(2); chain != nil {
+		t.Fatalf("got %d handlers for unknown message without NoRoute", len(chain))
+	}
+
+	r.NoRoute(record(&trace, "noRoute"))
+	runChain(r, 2)
+	want := []string{"global", "noRoute"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("got %v, want %v", trace, want)
+	}
+}
+
+func TestChainOnError(t *testing.T) {
+	var trace []string
+	var handled error
+	r := NewRouter()
+	r.OnError(func(c *Context, err error) {
+		handled = err
+	})
+	r.Register(1, func(c *Context) {
+		c.Error(errors.New("first"))
+	}, WrapE(func(c *Context) error {
+		return NewError(CodeBadRequest, "second")
+	}), record(&trace, "h3"))
+
+	c := runChain(r, 1)
+	if len(trace) != 0 {
+		t.Fatalf("handler executed after WrapE returned error: %v", trace)
+	}
+	if len(c.Errors()) != 2 {
+		t.Fatalf("got errors %v", c.Errors())
+	}
+	var e *Error
+	if !errors.As(handled, &e) || e.Code != CodeBadRequest {
+		t.Fatalf("OnError got %v", handled)
+	}
+}
+
+func TestCustomRecovery(t *testing.T) {
+	var trace []string
+	var recovered any
+	r := NewRouter()
+	r.Use(CustomRecovery(func(c *Context, err any) {
+		recovered = err
+	}))
+	r.Register(1, func(c *Context) {
+		panic("boom")
+	}, record(&trace, "h2"))
+
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	c := NewContext(NewSession(p1), &Message{id: 1})
+	r.handle(c)
+	if recovered != "boom" {
+		t.Fatalf("recovered %v", recovered)
+	}
+	if len(trace) != 0 || !c.IsAborted() {
+		t.Fatalf("chain not aborted after panic: %v", trace)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	session := NewSession(p1)
+
+	var err error
+	r := NewRouter()
+	r.Register(1, Timeout(10*time.Millisecond), func(c *Context) {
+		<-c.Done()
+		err = c.Err()
+	})
+	r.handle(NewContext(session, &Message{id: 1}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+
+	c := NewContext(session, &Message{id: 1})
+	_ = session.Close()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after session closed")
+	}
+}
+
+func TestContextKeys(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	session := NewSession(p1)
+	defer session.Close()
+
+	var got []any
+	var count any
+	r := NewRouter()
+	r.Use(func(c *Context) {
+		c.Set("user", "alice")
+	})
+	r.Register(1, func(c *Context) {
+		value, exists := c.Get("user")
+		if !exists {
+			t.Error("Get: key not found")
+		}
+		got = []any{value, c.MustGet("user"), c.Value("user")}
+
+		// 会话属性在多条消息之间保持
+		n, _ := c.Session().Get("count")
+		i, _ := n.(int)
+		c.Session().Set("count", i+1)
+		count, _ = c.Session().Get("count")
+	})
+
+	for range 2 {
+		r.handle(NewContext(session, &Message{id: 1}))
+	}
+	want := []any{"alice", "alice", "alice"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if count != 2 {
+		t.Fatalf("count = %v, want 2", count)
+	}
+	session.Delete("count")
+	if _, exists := session.Get("count"); exists {
+		t.Fatal("key exists after Delete")
+	}
+
+	// Set保存的值仅在当前消息中有效
+	c := NewContext(session, &Message{id: 1})
+	if _, exists := c.Get("user"); exists {
+		t.Fatal("key leaked into next message")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustGet did not panic on missing key")
+		}
+	}()
+	c.MustGet("user")
+}
@@ -0,0 +1,83 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSessionClosed 会话已关闭
+	ErrSessionClosed = errors.New("session closed")
+	// ErrNotConnected 客户端未连接
+	ErrNotConnected = errors.New("not connected")
+	// ErrSessionNotFound 会话不存在
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrServerClosed 服务已关闭
+	ErrServerClosed = errors.New("server closed")
+	// ErrHeartbeatTimeout 连续多次未收到心跳
+	ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+	// ErrReadTimeout 超过读空闲时间未收到消息
+	ErrReadTimeout = errors.New("read idle timeout")
+	// ErrWriteTimeout 消息未能在写超时时间内发送完成
+	ErrWriteTimeout = errors.New("write timeout")
+	// ErrTooManyUnknown 收到的未注册消息超过Router.SetMaxUnknown的限制
+	ErrTooManyUnknown = errors.New("too many unknown messages")
+	// ErrQueueFull 发送队列已满
+	ErrQueueFull = errors.New("write queue full")
+	// ErrKicked 会话被服务端踢下线
+	ErrKicked = errors.New("kicked")
+	// ErrChecksum 帧校验和不匹配或缺少校验和，连接会被关闭
+	ErrChecksum = errors.New("frame checksum mismatch")
+	// ErrMessageTooLarge 帧或head超过大小限制，具体信息见MessageTooLargeError
+	ErrMessageTooLarge = errors.New("message too large")
+
+	// errTerminal 收到退出信号
+	errTerminal = errors.New("terminal")
+	// errInvalidFrame 帧的总长度与各字段长度不一致，数据流已经无法继续解析
+	errInvalidFrame = errors.New("invalid frame length")
+)
+
+// MessageTooLargeError 帧或head超过大小限制，errors.Is(err, ErrMessageTooLarge)为true
+type MessageTooLargeError struct {
+	Field string // frame: 整个帧，header: head
+	Size  uint64
+	Limit uint64
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message too large: %s size %d exceeds limit %d", e.Field, e.Size, e.Limit)
+}
+
+func (e *MessageTooLargeError) Is(target error) bool {
+	return target == ErrMessageTooLarge
+}
+
+// 标准错误码
+const (
+	CodeBadRequest int32 = 400 // 请求消息无法解析
+	CodeNotFound   int32 = 404 // 未注册的消息id
+	CodeInternal   int32 = 500 // 处理函数内部错误
+)
+
+// Error 标准错误响应，以JSON编码通过MsgIDError发送给对端
+type Error struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewError(code int32, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("code=%d message=%s", e.Code, e.Message)
+}
+
+// toError 将任意错误转换为标准错误响应
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return NewError(CodeInternal, err.Error())
+}
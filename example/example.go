@@ -45,7 +45,7 @@ func main() {
 func ServerExample() {
 	// 消息路由
 	r := tcp.NewRouter()
-	r.Use(LogHandler)
+	r.Use(tcp.Recovery(), LogHandler)
 	register(r)
 
 	// 服务端
@@ -100,9 +100,10 @@ func ClientExample() {
 func register(r *tcp.Router) {
 	r.Register(PingMsgId, Pong, Pong)
 	r.Register(TextMsgId, Text)
-	r.Register(JSONMsgId, JSON)
-	r.Register(ReqFileMsgId, HandleRequestFile)
-	r.Register(BinaryMsgId, HandleSaveFile)
+	tcp.Handle(r, JSONMsgId, TextMsgId, JSON)
+	r.Register(ReqFileMsgId, tcp.WrapE(HandleRequestFile))
+	r.Register(BinaryMsgId, tcp.WrapE(HandleSaveFile))
+	r.Register(tcp.MsgIDError, HandleError)
 }
 
 // handlers
@@ -172,56 +173,54 @@ func Text(c *tcp.Context) {
 	})
 }
 
-func JSON(c *tcp.Context) {
-	var body map[string]interface{}
-	err := c.BindJSON(&body)
-	if err != nil {
-		_ = c.SendText(TextMsgId, err.Error())
-		return
-	}
-	log.Printf("%s: %#v", c.Remote(), body)
+type JSONRequest struct {
+	Code int `json:"code"`
 }
 
-func HandleRequestFile(c *tcp.Context) {
+type JSONResponse struct {
+	Received int `json:"received"`
+}
+
+func JSON(c *tcp.Context, req *JSONRequest) (*JSONResponse, error) {
+	log.Printf("%s: %#v", c.Remote(), req)
+	return &JSONResponse{Received: req.Code}, nil
+}
+
+func HandleRequestFile(c *tcp.Context) error {
 	var body map[string]interface{}
 	err := c.BindJSON(&body)
 	if err != nil {
-		_ = c.SendText(TextMsgId, err.Error())
-		return
+		return tcp.NewError(tcp.CodeBadRequest, err.Error())
 	}
 	filename, ok := body["file"].(string)
 	if !ok {
-		_ = c.SendText(TextMsgId, "error: filename")
-		return
+		return tcp.NewError(tcp.CodeBadRequest, "error: filename")
 	}
 	length, ok := body["length"].(float64)
 	if !ok || length == 0 {
-		_ = c.SendText(TextMsgId, "error: length")
-		return
+		return tcp.NewError(tcp.CodeBadRequest, "error: length")
 	}
 	offset, ok := body["offset"].(float64)
 	if !ok {
 		offset = 0
 	}
 	if int(length) > 1024*1024*5 {
-		_ = c.SendText(TextMsgId, "error: length too long")
-		return
+		return tcp.NewError(tcp.CodeBadRequest, "error: length too long")
 	}
 
 	var data = make([]byte, int(length))
 	f, err := os.Open(filename)
 	if err != nil {
-		_ = c.SendText(TextMsgId, err.Error())
-		return
+		return err
 	}
+	defer f.Close()
 
 	n, err := f.ReadAt(data, int64(offset))
-	if err != nil {
-		_ = c.SendText(TextMsgId, err.Error())
-		return
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
 	}
 
-	_ = c.SendStream(BinaryMsgId,
+	return c.SendStream(BinaryMsgId,
 		map[string]interface{}{
 			"offset": offset,
 			"length": n,
@@ -230,19 +229,18 @@ func HandleRequestFile(c *tcp.Context) {
 	)
 }
 
-func HandleSaveFile(c *tcp.Context) {
+func HandleSaveFile(c *tcp.Context) error {
 	var headers map[string]interface{}
 	err := c.HeaderBindJSON(&headers)
 	if err != nil {
-		_ = c.SendText(TextMsgId, err.Error())
-		return
+		return tcp.NewError(tcp.CodeBadRequest, err.Error())
 	}
 
 	f, err := os.Create("./save.txt")
 	if err != nil {
-		_ = c.SendText(TextMsgId, err.Error())
-		return
+		return err
 	}
+	defer f.Close()
 
 	offset, ok := headers["offset"].(float64)
 	if ok {
@@ -255,8 +253,15 @@ func HandleSaveFile(c *tcp.Context) {
 	}
 
 	_, err = c.CopyTo(f)
+	return err
+}
 
+func HandleError(c *tcp.Context) {
+	var e tcp.Error
+	err := c.BindJSON(&e)
 	if err != nil {
-		_ = c.SendText(TextMsgId, err.Error())
+		log.Println(err)
+		return
 	}
+	log.Printf("%s: error %d %s", c.Remote(), e.Code, e.Message)
 }
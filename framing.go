@@ -0,0 +1,39 @@
+package tcp
+
+import "encoding/binary"
+
+// framing 帧格式配置，读写两端使用相同的限制
+type framing struct {
+	maxMsgSize    uint64           // 最大帧长度，包括4字节总长度
+	maxHeaderSize uint64           // 最大head长度，不包括关联id
+	order         binary.ByteOrder // 整数字段的字节序
+
+	compression       Compression // 发送时使用的压缩算法
+	compressThreshold int         // 消息体达到该长度时压缩
+	maxDecompressed   uint64      // 解压后消息体的最大长度，0表示与maxMsgSize相同
+}
+
+var defaultFraming = framing{
+	maxMsgSize:    MaxMsgSize,
+	maxHeaderSize: maxHeadLength,
+	order:         binary.LittleEndian,
+}
+
+// check 检查head和整个帧的长度
+func (f *framing) check(headerSize, frameSize uint64) error {
+	if headerSize > f.maxHeaderSize {
+		return &MessageTooLargeError{Field: "header", Size: headerSize, Limit: f.maxHeaderSize}
+	}
+	if frameSize > f.maxMsgSize {
+		return &MessageTooLargeError{Field: "frame", Size: frameSize, Limit: f.maxMsgSize}
+	}
+	return nil
+}
+
+// decompressLimit 解压后消息体的最大长度
+func (f *framing) decompressLimit() uint64 {
+	if f.maxDecompressed > 0 {
+		return f.maxDecompressed
+	}
+	return f.maxMsgSize
+}
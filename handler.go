@@ -0,0 +1,83 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/myeof/gotcp/pkg/logger"
+)
+
+// DefaultErrorHandler 默认错误处理函数，记录日志并向对端发送标准错误响应，
+// *Error类型的错误使用其中的错误码，其他错误使用CodeInternal
+func DefaultErrorHandler(c *Context, err error) {
+	logger.Warnw("Handler error", "remote", c.Remote(), "msgId", c.MsgID(), "error", err)
+	_ = c.SendError(err)
+}
+
+// WrapE 将返回error的处理函数转换为普通处理函数，返回错误时调用Context.AbortWithError
+func WrapE(f func(c *Context) error) func(c *Context) {
+	return func(c *Context) {
+		if err := f(c); err != nil {
+			c.AbortWithError(err)
+		}
+	}
+}
+
+// NotFound 向对端发送CodeNotFound标准错误响应，配合Router.NoRoute使用
+// 收到的是错误响应时不再回复，避免双方互相回复错误
+func NotFound(c *Context) {
+	if c.MsgID() == MsgIDError {
+		return
+	}
+	_ = c.SendError(NewError(CodeNotFound, fmt.Sprintf("unknown message id: %d", c.MsgID())))
+}
+
+// Handle 注册类型化的处理函数
+// 收到msgID消息时使用路由配置的编解码器将消息体解析为Req，调用fn，
+// 并将返回的Resp编码后以respID响应；解析失败或fn返回错误时通过Context.Error交给Router.OnError处理，
+// 默认发送标准错误响应(MsgIDError)。
+// 如果请求由Call发起，响应会自动带上关联id。r可以是Router或RouterGroup
+func Handle[Req, Resp any](r Routes, msgID, respID int32, fn func(c *Context, req *Req) (*Resp, error)) {
+	r.Register(msgID, func(c *Context) {
+		req := new(Req)
+		if len(c.msg.body) > 0 {
+			err := c.Bind(req)
+			if err != nil {
+				c.AbortWithError(NewError(CodeBadRequest, err.Error()))
+				return
+			}
+		}
+
+		resp, err := fn(c, req)
+		if err != nil {
+			c.AbortWithError(err)
+			return
+		}
+
+		var body []byte
+		if resp != nil {
+			body, err = c.Codec().Marshal(resp)
+			if err != nil {
+				c.AbortWithError(err)
+				return
+			}
+		}
+		_ = c.Reply(respID, body)
+	})
+}
+
+// Timeout 为后续处理函数设置超时时间，超时后Context被取消，
+// 通过Context发送的消息也遵循该截止时间。可用于全局、分组或单个路由
+func Timeout(d time.Duration) func(c *Context) {
+	return func(c *Context) {
+		parent := c.ctx
+		ctx, cancel := context.WithTimeout(parent, d)
+		defer func() {
+			cancel()
+			c.ctx = parent
+		}()
+		c.ctx = ctx
+		c.Next()
+	}
+}
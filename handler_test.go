@@ -0,0 +1,70 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type addRequest struct {
+	A, B int
+}
+
+type addResponse struct {
+	Sum int
+}
+
+func TestHandle(t *testing.T) {
+	r := NewRouter()
+	Handle(r, 1, 2, func(c *Context, req *addRequest) (*addResponse, error) {
+		if req.A < 0 {
+			return nil, NewError(422, "negative")
+		}
+		return &addResponse{Sum: req.A + req.B}, nil
+	})
+
+	p1, p2 := net.Pipe()
+	server := NewClient()
+	go server.ConnectConn(p1, r)
+	defer server.Close()
+
+	connected := make(chan struct{})
+	client := NewClient()
+	client.SetOnConnected(func(c *Context) {
+		close(connected)
+	})
+	go client.ConnectConn(p2, NewRouter())
+	defer client.Close()
+	<-connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(addRequest{A: 1, B: 2})
+	msg, err := client.Call(ctx, 1, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp addResponse
+	if err = json.Unmarshal(msg.Body(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID() != 2 || resp.Sum != 3 {
+		t.Fatalf("got id=%d sum=%d", msg.ID(), resp.Sum)
+	}
+
+	body, _ = json.Marshal(addRequest{A: -1})
+	_, err = client.Call(ctx, 1, body)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != 422 {
+		t.Fatalf("got %v", err)
+	}
+
+	_, err = client.Call(ctx, 1, []byte("{"))
+	if !errors.As(err, &e) || e.Code != CodeBadRequest {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -2,20 +2,47 @@ package tcp
 
 import (
 	"fmt"
+	"math"
+	"sync/atomic"
 )
 
-const MaxMsgSize = 1024 * 1024 * 2 // 1MB
+// MaxMsgSize 默认的最大帧长度2MB，可以通过SetMaxMsgSize修改
+const MaxMsgSize = 1024 * 1024 * 2
+
+// 框架保留的消息id，业务消息id不要使用
+const (
+	MsgIDPing  int32 = math.MinInt32 + iota // 心跳请求
+	MsgIDPong                               // 心跳响应
+	MsgIDError                              // 标准错误响应，消息体为JSON编码的Error
+)
+
+// 帧标志位，保存在head长度字段的高8位，第4、5位为压缩算法(见Compression)
+const (
+	flagRequest  uint8 = 1 << iota // 请求帧，head前带4字节关联id
+	flagReply                      // 响应帧，head前带4字节关联id
+	flagChecksum                   // 帧末尾带4字节CRC32C校验和
+)
+
+// maxHeadLength head长度字段低24位可表示的最大长度
+const maxHeadLength = 1<<24 - 1
 
 type Message struct {
 	size uint32
 
 	id int32
 
+	flags uint8
+	seq   uint32 // 关联id，请求帧和响应帧有效
+
 	headLength uint32
 	header     []byte
 
 	bodyLength uint32
 	body       []byte
+
+	// 开启SetMessagePool时header和body来自bufferPool，引用计数归零时归还
+	pooled bool
+	refs   atomic.Int32
 }
 
 func NewMessage() *Message {
@@ -26,6 +53,16 @@ func (msg *Message) ID() int32 {
 	return msg.id
 }
 
+// Seq 返回关联id，普通消息返回0
+func (msg *Message) Seq() uint32 {
+	return msg.seq
+}
+
+// IsReply 是否为Call的响应
+func (msg *Message) IsReply() bool {
+	return msg.flags&flagReply != 0
+}
+
 func (msg *Message) Header() []byte {
 	return msg.header
 }
@@ -38,3 +75,50 @@ func (msg *Message) String() string {
 	return fmt.Sprintf("ID=%d HeadLan=%d DataLen=%d",
 		msg.id, msg.headLength, msg.bodyLength)
 }
+
+// Clone 深拷贝消息，返回的消息不使用对象池，可以在处理函数返回后继续使用
+func (msg *Message) Clone() *Message {
+	c := &Message{
+		size:       msg.size,
+		id:         msg.id,
+		flags:      msg.flags,
+		seq:        msg.seq,
+		headLength: msg.headLength,
+		bodyLength: msg.bodyLength,
+	}
+	if msg.header != nil {
+		c.header = append([]byte(nil), msg.header...)
+	}
+	if msg.body != nil {
+		c.body = append([]byte(nil), msg.body...)
+	}
+	return c
+}
+
+// retain 增加引用计数
+func (msg *Message) retain() {
+	if msg.pooled {
+		msg.refs.Add(1)
+	}
+}
+
+// Release 减少引用计数，归零时将header和body归还对象池，之后不能再访问消息内容
+// 只需要对Context.Retain返回的消息调用，未使用对象池的消息调用无效果
+func (msg *Message) Release() {
+	if !msg.pooled || msg.refs.Add(-1) != 0 {
+		return
+	}
+	if msg.header != nil {
+		bufferPool.Put(msg.header)
+		msg.header = nil
+	}
+	if msg.body != nil {
+		bufferPool.Put(msg.body)
+		msg.body = nil
+	}
+}
+
+// detach 转移消息所有权，之后不再归还对象池，由GC回收
+func (msg *Message) detach() {
+	msg.pooled = false
+}
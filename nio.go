@@ -7,6 +7,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"os"
 	"time"
 
 	"github.com/myeof/gotcp/pkg/logger"
@@ -14,34 +15,53 @@ import (
 
 // write
 
-// writeMessageHeader 构建消息头部到指定的缓冲区
-func writeMessageHeader(headerBuf []byte, msgID int32, headers, body []byte) error {
-	// 计算包头长度: 4字节总长度 + 4字节消息ID + 4字节head长度 + head + 4字节body长度
-	headSize := 4 + 4 + 4 + len(headers) + 4
-	totalSize := headSize + len(body)
+// frameHeadSize 计算包头长度: 4字节总长度 + 4字节消息ID + 4字节head长度 + [4字节关联id] + head + 4字节body长度
+func frameHeadSize(flags uint8, headers []byte) int {
+	size := 4 + 4 + 4 + len(headers) + 4
+	if flags&(flagRequest|flagReply) != 0 {
+		size += 4
+	}
+	return size
+}
+
+// writeMessageHeader 按指定字节序构建消息头部到指定的缓冲区
+// head长度字段的高8位为帧标志位，带关联id的帧在head前写入4字节关联id，
+// 带校验和的帧总长度包括末尾的4字节校验和
+func writeMessageHeader(headerBuf []byte, order binary.ByteOrder, msgID int32, flags uint8, seq uint32, headers, body []byte) error {
+	headSize := frameHeadSize(flags, headers)
+	totalSize := headSize + len(body) + frameTailSize(flags)
+	headLength := headSize - 16
 
 	// 确保缓冲区有足够空间
 	if len(headerBuf) < headSize {
 		return errors.New("buffer too small")
 	}
+	if headLength > maxHeadLength {
+		return errors.New("header too long")
+	}
 
 	offset := 0
 	// 写入总长度
-	binary.LittleEndian.PutUint32(headerBuf[offset:offset+4], uint32(totalSize))
+	order.PutUint32(headerBuf[offset:offset+4], uint32(totalSize))
 	offset += 4
 	// 写入消息ID
-	binary.LittleEndian.PutUint32(headerBuf[offset:offset+4], uint32(msgID))
+	order.PutUint32(headerBuf[offset:offset+4], uint32(msgID))
 	offset += 4
-	// 写入头长度
-	binary.LittleEndian.PutUint32(headerBuf[offset:offset+4], uint32(len(headers)))
+	// 写入标志位和头长度
+	order.PutUint32(headerBuf[offset:offset+4], uint32(flags)<<24|uint32(headLength))
 	offset += 4
+	// 写入关联id
+	if flags&(flagRequest|flagReply) != 0 {
+		order.PutUint32(headerBuf[offset:offset+4], seq)
+		offset += 4
+	}
 	// 写入头部
 	if len(headers) > 0 {
 		copy(headerBuf[offset:offset+len(headers)], headers)
 		offset += len(headers)
 	}
 	// 写入体长度
-	binary.LittleEndian.PutUint32(headerBuf[offset:offset+4], uint32(len(body)))
+	order.PutUint32(headerBuf[offset:offset+4], uint32(len(body)))
 
 	return nil
 }
@@ -68,117 +88,93 @@ func applyRateLimit(bodySize int) *time.Timer {
 }
 
 func WriteMsg(session *Session, msgID int32, headers, body []byte) error {
-	if len(body) > MaxMsgSize {
-		return errors.New("message too long")
-	}
-
-	// 计算需要的头部缓冲区大小
-	headSize := 4 + 4 + 4 + len(headers) + 4
+	return writeMsg(context.Background(), session, msgID, 0, 0, headers, body)
+}
 
-	// 从对象池获取缓冲区
-	headerBuf := bufferPool.Get(headSize)
-	defer bufferPool.Put(headerBuf)
+func WriteMsgWithContext(ctx context.Context, session *Session, msgID int32, headers, body []byte) error {
+	return writeMsg(ctx, session, msgID, 0, 0, headers, body)
+}
 
-	// 构建消息头部到池中的缓冲区
-	err := writeMessageHeader(headerBuf, msgID, headers, body)
-	if err != nil {
+func writeMsg(ctx context.Context, session *Session, msgID int32, flags uint8, seq uint32, headers, body []byte) error {
+	select {
+	case <-session.closeChan:
+		return ErrSessionClosed
+	default:
+	}
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	// 应用限速
-	t := applyRateLimit(len(body))
-	if t != nil {
-		defer t.Stop()
+	if session.checksum {
+		flags |= flagChecksum
 	}
 
-	// 发送数据，加锁防止并发发送
-	session.Lock()
-	defer session.Unlock()
-
-	// 发送header（只发送实际使用的部分）
-	_, err = session.Conn().Write(headerBuf[:headSize])
+	// 按压缩前的长度检查，保证对端解压后不会超过限制
+	headSize := frameHeadSize(flags, headers)
+	tailSize := frameTailSize(flags)
+	err := session.framing.check(uint64(len(headers)), uint64(headSize+len(body)+tailSize))
 	if err != nil {
 		return err
 	}
 
-	// 发送body
-	if len(body) > 0 {
-		_, err = session.Conn().Write(body)
-		if err != nil {
-			return err
+	// 消息体达到阈值时压缩，压缩后没有变小则原样发送
+	if c := session.framing.compression; c != CompressNone && len(body) >= session.framing.compressThreshold {
+		if b, ok := compress(c, body); ok {
+			body = b
+			flags |= uint8(c) << compressShift
 		}
 	}
 
-	// 等待限速完成
-	if t != nil {
-		<-t.C
-	}
-
-	return nil
-}
-
-func WriteMsgWithContext(ctx context.Context, session *Session, msgID int32, headers, body []byte) error {
-	if len(body) > MaxMsgSize {
-		return errors.New("message too long")
-	}
-
-	// 计算需要的头部缓冲区大小
-	headSize := 4 + 4 + 4 + len(headers) + 4
-
-	// 从对象池获取缓冲区
-	headerBuf := bufferPool.Get(headSize)
-	defer bufferPool.Put(headerBuf)
+	// 从对象池获取缓冲区，校验和放在头部之后
+	headerBuf := bufferPool.Get(headSize + tailSize)
 
 	// 构建消息头部到池中的缓冲区
-	err := writeMessageHeader(headerBuf, msgID, headers, body)
+	err = writeMessageHeader(headerBuf, session.framing.order, msgID, flags, seq, headers, body)
 	if err != nil {
+		bufferPool.Put(headerBuf)
 		return err
 	}
+	f := &outFrame{head: headerBuf[:headSize], body: body}
+	if tailSize > 0 {
+		f.tail = headerBuf[headSize:]
+		session.framing.order.PutUint32(f.tail, frameChecksum(f.head[4:], body))
+	}
 
-	// 应用限速
+	// 应用限速，在获取写锁之前等待，避免阻塞其他发送者
 	t := applyRateLimit(len(body))
 	if t != nil {
-		defer t.Stop()
-	}
-
-	// 发送数据，加锁防止并发发送
-	session.Lock()
-	defer session.Unlock()
-
-	// 设置写入超时
-	deadline, ok := ctx.Deadline()
-	if ok {
-		err := session.Conn().SetWriteDeadline(deadline)
-		if err != nil {
-			return err
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			bufferPool.Put(headerBuf)
+			return ctx.Err()
 		}
 	}
 
-	// 发送header（只发送实际使用的部分）
-	_, err = session.Conn().Write(headerBuf[:headSize])
-	if err != nil {
-		return err
-	}
-
-	// 发送body
-	if len(body) > 0 {
-		_, err = session.Conn().Write(body)
+	if session.queue != nil {
+		// 异步发送，缓冲区由发送goroutine归还
+		err = session.enqueue(ctx, f)
 		if err != nil {
-			return err
+			bufferPool.Put(headerBuf)
 		}
+		return err
 	}
+	defer bufferPool.Put(headerBuf)
 
-	// 等待限速完成
-	if t != nil {
-		<-t.C
-	}
-
-	return nil
+	// 发送数据，加锁防止并发发送
+	session.Lock()
+	defer session.Unlock()
+	deadline, ok := ctx.Deadline()
+	return session.writeFrames(deadline, ok, f)
 }
 
 // read
 
-func readLength(conn *net.TCPConn) (dataSize uint32, err error) {
+// ReadMsgId 从连接读取4字节小端序的消息id
+//
+// Deprecated: 不经过会话的读缓冲区，也不遵循SetByteOrder，使用ReadMsg读取完整的帧
+func ReadMsgId(conn net.Conn) (msgId int32, err error) {
 	// 使用对象池：数据读取后立即使用，生命周期很短
 	buf := bufferPool.Get(4)
 	defer bufferPool.Put(buf)
@@ -187,41 +183,78 @@ func readLength(conn *net.TCPConn) (dataSize uint32, err error) {
 	if err != nil {
 		return 0, err
 	}
-	err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &dataSize)
+	err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &msgId)
 	if err != nil {
 		return 0, err
 	}
-	return dataSize, nil
+	return msgId, nil
 }
 
-func ReadMsgId(conn *net.TCPConn) (msgId int32, err error) {
-	// 使用对象池：数据读取后立即使用，生命周期很短
-	buf := bufferPool.Get(4)
-	defer bufferPool.Put(buf)
-
-	_, err = io.ReadFull(conn, buf)
+func ReadMsg(session *Session) (*Message, error) {
+	msg, err := readMsg(session)
 	if err != nil {
-		return 0, err
+		if session.readTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, ErrReadTimeout
+		}
+		return nil, err
 	}
-	err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &msgId)
-	if err != nil {
-		return 0, err
+	session.lastRead.Store(time.Now().UnixNano())
+	return msg, nil
+}
+
+// allocBuffer 分配消息数据缓冲区，开启对象池时从bufferPool获取
+func (s *Session) allocBuffer(size int) []byte {
+	if s.pooled {
+		return bufferPool.Get(size)
 	}
-	return msgId, nil
+	return make([]byte, size)
 }
 
-func ReadMsg(session *Session) (*Message, error) {
-	var err error
+// readMsg 从会话的缓冲读取器解析一个完整的帧
+// 帧头的定长部分一次读入会话的scratch缓冲区直接解码，避免逐个字段读取和额外分配
+func readMsg(session *Session) (_ *Message, err error) {
 	var msg = NewMessage()
-	// 读取总长度
-	msgSize, err := readLength(session.Conn())
+	if session.pooled {
+		msg.pooled = true
+		msg.refs.Store(1)
+		// 读取失败时归还已分配的缓冲区
+		defer func() {
+			if err != nil {
+				msg.Release()
+			}
+		}()
+	}
+	r := session.reader
+	buf := session.rbuf[:]
+	// 设置读空闲超时
+	if session.readTimeout > 0 {
+		err = session.Conn().SetReadDeadline(time.Now().Add(session.readTimeout))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 读取总长度、消息id、标志位和head长度
+	_, err = io.ReadFull(r, buf[:12])
 	if err != nil {
 		return nil, err
 	}
-	if msgSize > MaxMsgSize {
-		return nil, errors.New("message too long")
+	f := &session.framing
+	msgSize := f.order.Uint32(buf[0:4])
+	err = f.check(0, uint64(msgSize))
+	if err != nil {
+		return nil, err
 	}
 	msg.size = msgSize
+	msg.id = int32(f.order.Uint32(buf[4:8]))
+	headLength := f.order.Uint32(buf[8:12])
+	msg.flags = uint8(headLength >> 24)
+	headLength &= maxHeadLength
+	// 总长度 = 16字节定长字段 + head长度字段(包括关联id) + body + 校验和
+	fixedSize := uint64(16 + frameTailSize(msg.flags))
+	if fixedSize+uint64(headLength) > uint64(msgSize) {
+		return nil, errInvalidFrame
+	}
+	bodyExpected := uint64(msgSize) - fixedSize - uint64(headLength)
 	// 限速
 	var t *time.Timer
 	if receiveRateLimiter != nil {
@@ -237,38 +270,79 @@ func ReadMsg(session *Session) (*Message, error) {
 			logger.Warn("receiveRateLimiter.ReserveN error")
 		}
 	}
-	// 读取消息id
-	msg.id, err = ReadMsgId(session.Conn())
-	if err != nil {
-		return nil, err
+	if msg.flags&(flagRequest|flagReply) != 0 {
+		// 读取关联id
+		if headLength < 4 {
+			return nil, errInvalidFrame
+		}
+		_, err = io.ReadFull(r, buf[12:16])
+		if err != nil {
+			return nil, err
+		}
+		msg.seq = f.order.Uint32(buf[12:16])
+		headLength -= 4
 	}
-	// 读取head长度
-	headLength, err := readLength(session.Conn())
+	err = f.check(uint64(headLength), 0)
 	if err != nil {
 		return nil, err
 	}
+	msg.headLength = headLength
 	if headLength > 0 {
-		// 读取head - 不能使用对象池，因为数据需要长期保存
-		// 这些数据会传递给消息处理逻辑，生命周期较长
-		msg.header = make([]byte, headLength)
-		_, err = io.ReadFull(session.Conn(), msg.header)
+		// 读取head - 未开启对象池时数据会传递给消息处理逻辑，生命周期较长，需要单独分配
+		msg.header = session.allocBuffer(int(headLength))
+		_, err = io.ReadFull(r, msg.header)
 		if err != nil {
 			return nil, err
 		}
 	}
 	// 读取body长度
-	bodyLength, err := readLength(session.Conn())
+	_, err = io.ReadFull(r, buf[:4])
 	if err != nil {
 		return nil, err
 	}
+	bodyLength := f.order.Uint32(buf[:4])
+	if uint64(bodyLength) != bodyExpected {
+		return nil, errInvalidFrame
+	}
+	msg.bodyLength = bodyLength
 	if bodyLength > 0 {
-		// 读取body - 不能使用对象池，因为数据需要长期保存
-		// 这些数据会传递给消息处理逻辑，生命周期较长
-		msg.body = make([]byte, bodyLength)
-		_, err = io.ReadFull(session.Conn(), msg.body)
+		// 读取body
+		msg.body = session.allocBuffer(int(bodyLength))
+		_, err = io.ReadFull(r, msg.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 校验和覆盖总长度之后的所有字段，开启SetChecksum时要求对端必须发送校验和
+	if msg.flags&flagChecksum != 0 {
+		parts := [][]byte{buf[4:12], buf[12:16], msg.header, buf[:4], msg.body}
+		if msg.flags&(flagRequest|flagReply) == 0 {
+			parts[1] = nil
+		}
+		sum := frameChecksum(parts...)
+		_, err = io.ReadFull(r, buf[:4])
 		if err != nil {
 			return nil, err
 		}
+		if f.order.Uint32(buf[:4]) != sum {
+			return nil, ErrChecksum
+		}
+	} else if session.checksum {
+		return nil, ErrChecksum
+	}
+	// 解压消息体，处理函数看到的是解压后的数据
+	if c := Compression((msg.flags & compressMask) >> compressShift); c != CompressNone {
+		var body []byte
+		body, err = decompress(c, msg.body, f.decompressLimit())
+		if err != nil {
+			return nil, err
+		}
+		if msg.pooled && msg.body != nil {
+			bufferPool.Put(msg.body)
+		}
+		msg.body = body
+		msg.bodyLength = uint32(len(body))
+		msg.flags &^= compressMask
 	}
 	if t != nil {
 		<-t.C
@@ -0,0 +1,426 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// newBenchSession 建立本地TCP连接，对端丢弃收到的数据
+func newBenchSession(b *testing.B) *Session {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	session := NewSession(conn)
+	b.Cleanup(func() { _ = session.Close() })
+	return session
+}
+
+func BenchmarkWriteMsg(b *testing.B) {
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"small", 64},
+		{"large", 64 * 1024},
+	}
+	modes := []struct {
+		name  string
+		queue int
+		batch int
+	}{
+		{"sync", 0, 0},
+		{"queue", 1024, 1},
+		{"coalesce", 1024, 64},
+	}
+	for _, size := range sizes {
+		for _, mode := range modes {
+			b.Run(size.name+"/"+mode.name, func(b *testing.B) {
+				session := newBenchSession(b)
+				if mode.queue > 0 {
+					session.startWriter(mode.queue, OverflowBlock, mode.batch)
+				}
+				header := []byte(`{"k":"v"}`)
+				body := make([]byte, size.size)
+				b.SetBytes(int64(len(header) + len(body)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if err := WriteMsg(session, 1, header, body); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+				if err := session.Flush(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+// newReadBenchSession 建立本地TCP连接，对端循环发送编码好的帧
+func newReadBenchSession(b *testing.B, frame []byte) *Session {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// 一次写入多个帧，减少发送端对结果的影响
+		batch := bytes.Repeat(frame, 64*1024/len(frame)+1)
+		for {
+			if _, err := conn.Write(batch); err != nil {
+				return
+			}
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	session := NewSession(conn)
+	b.Cleanup(func() { _ = session.Close() })
+	return session
+}
+
+// readLength 从连接读取4字节小端序长度，readMsgLegacy使用
+func readLength(conn net.Conn) (dataSize uint32, err error) {
+	// 使用对象池：数据读取后立即使用，生命周期很短
+	buf := bufferPool.Get(4)
+	defer bufferPool.Put(buf)
+
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &dataSize)
+	if err != nil {
+		return 0, err
+	}
+	return dataSize, nil
+}
+
+// readMsgLegacy 逐个字段从连接读取的旧实现，用于对比
+func readMsgLegacy(session *Session) (*Message, error) {
+	conn := session.Conn()
+	msg := NewMessage()
+	msgSize, err := readLength(conn)
+	if err != nil {
+		return nil, err
+	}
+	msg.size = msgSize
+	msg.id, err = ReadMsgId(conn)
+	if err != nil {
+		return nil, err
+	}
+	headLength, err := readLength(conn)
+	if err != nil {
+		return nil, err
+	}
+	msg.flags = uint8(headLength >> 24)
+	msg.headLength = headLength & maxHeadLength
+	if msg.headLength > 0 {
+		msg.header = make([]byte, msg.headLength)
+		if _, err = io.ReadFull(conn, msg.header); err != nil {
+			return nil, err
+		}
+	}
+	msg.bodyLength, err = readLength(conn)
+	if err != nil {
+		return nil, err
+	}
+	if msg.bodyLength > 0 {
+		msg.body = make([]byte, msg.bodyLength)
+		if _, err = io.ReadFull(conn, msg.body); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+func BenchmarkReadMsg(b *testing.B) {
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"small", 64},
+		{"large", 64 * 1024},
+	}
+	modes := []struct {
+		name   string
+		read   func(*Session) (*Message, error)
+		pooled bool
+	}{
+		{"buffered", ReadMsg, false},
+		{"pooled", ReadMsg, true},
+		{"legacy", readMsgLegacy, false},
+	}
+	for _, size := range sizes {
+		for _, mode := range modes {
+			b.Run(size.name+"/"+mode.name, func(b *testing.B) {
+				header := []byte(`{"k":"v"}`)
+				body := make([]byte, size.size)
+				frame := make([]byte, frameHeadSize(0, header)+len(body))
+				if err := writeMessageHeader(frame, binary.LittleEndian, 1, 0, 0, header, body); err != nil {
+					b.Fatal(err)
+				}
+				copy(frame[frameHeadSize(0, header):], body)
+				session := newReadBenchSession(b, frame)
+				session.pooled = mode.pooled
+				b.SetBytes(int64(len(frame)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					msg, err := mode.read(session)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(msg.body) != size.size {
+						b.Fatalf("body length %d", len(msg.body))
+					}
+					msg.Release()
+				}
+			})
+		}
+	}
+}
+
+func TestReadMsgPooled(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	go func() {
+		_ = WriteMsg(NewSession(p1), 1, []byte("head"), []byte("body"))
+	}()
+
+	session := NewSession(p2)
+	session.pooled = true
+	msg, err := ReadMsg(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewContext(session, msg)
+	retained := c.Retain()
+	clone := msg.Clone()
+
+	// 处理链结束后释放，Retain的引用仍然有效
+	msg.Release()
+	if string(retained.Body()) != "body" {
+		t.Fatalf("body %q", retained.Body())
+	}
+	retained.Release()
+	if retained.Body() != nil || retained.Header() != nil {
+		t.Fatal("buffers not returned to pool")
+	}
+	if string(clone.Header()) != "head" || string(clone.Body()) != "body" {
+		t.Fatalf("clone %q %q", clone.Header(), clone.Body())
+	}
+}
+
+func TestFraming(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	w, r := NewSession(p1), NewSession(p2)
+	w.framing.order = binary.BigEndian
+	r.framing.order = binary.BigEndian
+	r.framing.maxMsgSize = 64
+
+	// 发送端检查head和帧长度
+	w.framing.maxHeaderSize = 4
+	err := WriteMsg(w, 1, []byte("header"), nil)
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("got %v", err)
+	}
+	w.framing.maxHeaderSize = maxHeadLength
+
+	go func() {
+		_ = WriteMsg(w, 1, []byte("head"), []byte("body"))
+		_ = WriteMsg(w, 2, nil, make([]byte, 64))
+	}()
+	msg, err := ReadMsg(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID() != 1 || string(msg.Header()) != "head" || string(msg.Body()) != "body" {
+		t.Fatalf("got %s", msg)
+	}
+	// 接收端检查帧长度
+	_, err = ReadMsg(r)
+	var tooLarge *MessageTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Field != "frame" || !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// 读取开启校验的会话发送的原始帧
+	p1, p2 := net.Pipe()
+	w := NewSession(p1)
+	w.checksum = true
+	go func() {
+		_ = WriteMsg(w, 1, []byte("head"), []byte("body"))
+	}()
+	frame := make([]byte, frameHeadSize(flagChecksum, []byte("head"))+len("body")+checksumSize)
+	if _, err := io.ReadFull(p2, frame); err != nil {
+		t.Fatal(err)
+	}
+	_ = p1.Close()
+	_ = p2.Close()
+
+	read := func(frame []byte, checksum bool) (*Message, error) {
+		p1, p2 := net.Pipe()
+		defer p1.Close()
+		defer p2.Close()
+		go func() {
+			_, _ = p1.Write(frame)
+		}()
+		r := NewSession(p2)
+		r.checksum = checksum
+		return ReadMsg(r)
+	}
+	msg, err := read(frame, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Header()) != "head" || string(msg.Body()) != "body" {
+		t.Fatalf("got %s", msg)
+	}
+
+	corrupted := bytes.Clone(frame)
+	corrupted[len(corrupted)-6] ^= 1
+	if _, err = read(corrupted, false); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("got %v", err)
+	}
+
+	// 开启校验时对端必须带校验和
+	p1, p2 = net.Pipe()
+	go func() {
+		_ = WriteMsg(NewSession(p1), 1, nil, []byte("body"))
+		_ = p1.Close()
+	}()
+	r := NewSession(p2)
+	r.checksum = true
+	if _, err = ReadMsg(r); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("got %v", err)
+	}
+	_ = p2.Close()
+}
+
+func TestCompression(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"code":0,"message":"ok"}`), 100)
+	for _, c := range []Compression{CompressFlate, CompressGzip, CompressZlib} {
+		t.Run(c.String(), func(t *testing.T) {
+			p1, p2 := net.Pipe()
+			defer p1.Close()
+			defer p2.Close()
+			w, r := NewSession(p1), NewSession(p2)
+			w.framing.compression = c
+			w.framing.compressThreshold = defaultCompressThreshold
+			go func() {
+				_ = WriteMsg(w, 1, nil, body)
+				_ = WriteMsg(w, 2, nil, []byte("small"))
+			}()
+			msg, err := ReadMsg(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.size >= uint32(len(body)) || !bytes.Equal(msg.Body(), body) {
+				t.Fatalf("size %d body %d", msg.size, len(msg.Body()))
+			}
+			// 小于阈值的消息不压缩
+			msg, err = ReadMsg(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg.Body()) != "small" {
+				t.Fatalf("got %q", msg.Body())
+			}
+		})
+	}
+
+	// 发送端按压缩前的长度检查
+	w := NewSession(nil)
+	w.framing.compression = CompressGzip
+	err := WriteMsg(w, 1, nil, make([]byte, MaxMsgSize))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("got %v", err)
+	}
+
+	// 解压后超过限制
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	w = NewSession(p1)
+	r := NewSession(p2)
+	w.framing.compression = CompressGzip
+	r.framing.maxDecompressed = 1024
+	go func() {
+		_ = WriteMsg(w, 1, nil, make([]byte, 1024*1024))
+	}()
+	if _, err = ReadMsg(r); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestReadMsgInvalidFrame(t *testing.T) {
+	header, body := []byte("head"), []byte("body")
+	headSize := frameHeadSize(0, header)
+	frame := make([]byte, headSize+len(body))
+	if err := writeMessageHeader(frame, binary.LittleEndian, 1, 0, 0, header, body); err != nil {
+		t.Fatal(err)
+	}
+	copy(frame[headSize:], body)
+
+	read := func(frame []byte) error {
+		p1, p2 := net.Pipe()
+		defer p1.Close()
+		defer p2.Close()
+		go func() {
+			_, _ = p1.Write(frame)
+		}()
+		_, err := ReadMsg(NewSession(p2))
+		return err
+	}
+	if err := read(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	// head长度超出总长度
+	bad := bytes.Clone(frame)
+	binary.LittleEndian.PutUint32(bad[8:12], 1024)
+	if err := read(bad); !errors.Is(err, errInvalidFrame) {
+		t.Fatalf("got %v", err)
+	}
+	// body长度与总长度不一致
+	bad = bytes.Clone(frame)
+	binary.LittleEndian.PutUint32(bad[headSize-4:headSize], uint32(len(body)*2))
+	if err := read(bad); !errors.Is(err, errInvalidFrame) {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package tcp
+
+import (
+	"runtime/debug"
+
+	"github.com/myeof/gotcp/pkg/logger"
+)
+
+// Recovery 捕获处理函数中的panic，记录日志(包含连接地址、消息id和调用栈)并向对端发送CodeInternal标准错误响应
+func Recovery() func(c *Context) {
+	return CustomRecovery(func(c *Context, err any) {
+		_ = c.SendError(NewError(CodeInternal, "internal error"))
+	})
+}
+
+// CustomRecovery 捕获处理函数中的panic，记录日志后调用handle，handle中可自定义响应或上报
+func CustomRecovery(handle func(c *Context, err any)) func(c *Context) {
+	return func(c *Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Errorw("Panic recovered",
+					"remote", c.Remote(),
+					"msgId", c.MsgID(),
+					"error", err,
+					"stack", string(debug.Stack()),
+				)
+				c.Abort()
+				if handle != nil {
+					handle(c, err)
+				}
+			}
+		}()
+		c.Next()
+	}
+}
@@ -3,26 +3,60 @@ package tcp
 type Router struct {
 	middlewares []func(ctx *Context)
 	handlers    map[int32][]func(ctx *Context)
+
+	codec  Codec
+	codecs map[int32]Codec
+
+	// 未注册消息的处理
+	noRoute    []func(ctx *Context)
+	maxUnknown int
+
+	errorHandler func(ctx *Context, err error)
 }
 
 func NewRouter() *Router {
 	r := &Router{
 		middlewares: make([]func(ctx *Context), 0),
 		handlers:    make(map[int32][]func(ctx *Context)),
+		codec:       CodecJSON,
+		codecs:      make(map[int32]Codec),
+
+		errorHandler: DefaultErrorHandler,
 	}
 	return r
 }
 
+// OnError 设置错误处理函数，处理链执行完后如果通过Context.Error记录了错误，
+// 使用最后一个错误调用f，全部错误可通过Context.Errors获取。默认为DefaultErrorHandler，设置为nil时不处理
+func (r *Router) OnError(f func(ctx *Context, err error)) {
+	r.errorHandler = f
+}
+
+// SetCodec 设置默认编解码器，默认为CodecJSON
+func (r *Router) SetCodec(codec Codec) {
+	r.codec = codec
+}
+
+// SetRouteCodec 为指定消息设置编解码器，优先于SetCodec
+func (r *Router) SetRouteCodec(msgId int32, codec Codec) {
+	r.codecs[msgId] = codec
+}
+
+// GetCodec 返回指定消息使用的编解码器
+func (r *Router) GetCodec(msgId int32) Codec {
+	if codec, ok := r.codecs[msgId]; ok {
+		return codec
+	}
+	return r.codec
+}
+
 func (r *Router) Use(middleware ...func(ctx *Context)) {
 	r.middlewares = append(r.middlewares, middleware...)
 }
 
+// Register 注册消息处理函数，同一消息多次注册时按注册顺序追加
 func (r *Router) Register(msgId int32, handlers ...func(ctx *Context)) {
-	if _, ok := r.handlers[msgId]; ok {
-		r.handlers[msgId] = append(r.handlers[msgId], handlers...)
-	} else {
-		r.handlers[msgId] = handlers
-	}
+	r.handlers[msgId] = append(r.handlers[msgId], handlers...)
 }
 
 func (r *Router) GetMiddlewares() []func(ctx *Context) {
@@ -32,3 +66,94 @@ func (r *Router) GetMiddlewares() []func(ctx *Context) {
 func (r *Router) GetHandlers(msgId int32) []func(ctx *Context) {
 	return r.handlers[msgId]
 }
+
+// NoRoute 设置未注册消息的处理函数，同样会先执行全局中间件。
+// 可使用内置的NotFound向对端发送标准错误响应
+func (r *Router) NoRoute(handlers ...func(ctx *Context)) {
+	r.noRoute = handlers
+}
+
+// SetMaxUnknown 同一连接收到的未注册消息超过n条时关闭连接，0表示不限制
+func (r *Router) SetMaxUnknown(n int) {
+	r.maxUnknown = n
+}
+
+// hasRoute 消息是否已注册
+func (r *Router) hasRoute(msgId int32) bool {
+	return len(r.handlers[msgId]) > 0
+}
+
+// handlerChain 返回消息的完整处理链: 全局中间件 + 路由处理函数，
+// 未注册的消息使用NoRoute设置的处理函数，都没有时返回nil
+func (r *Router) handlerChain(msgId int32) []func(ctx *Context) {
+	handlers := r.handlers[msgId]
+	if len(handlers) == 0 {
+		handlers = r.noRoute
+	}
+	if len(handlers) == 0 {
+		return nil
+	}
+	chain := make([]func(ctx *Context), 0, len(r.middlewares)+len(handlers))
+	chain = append(chain, r.middlewares...)
+	return append(chain, handlers...)
+}
+
+// Routes Router和RouterGroup共同的路由注册接口
+type Routes interface {
+	Use(middleware ...func(ctx *Context))
+	Register(msgId int32, handlers ...func(ctx *Context))
+	Group(middlewares ...func(ctx *Context)) *RouterGroup
+}
+
+// Group 创建路由分组，分组内注册的消息除全局中间件外还会执行分组中间件
+func (r *Router) Group(middlewares ...func(ctx *Context)) *RouterGroup {
+	return &RouterGroup{
+		router:      r,
+		middlewares: middlewares,
+	}
+}
+
+// RouterGroup 路由分组
+// 消息处理链的执行顺序为: Router.Use注册的全局中间件 -> 外层分组中间件 -> 内层分组中间件 -> 处理函数，
+// 同一层内按注册顺序执行。分组中间件在Register时确定，之后调用Use不影响已注册的消息
+type RouterGroup struct {
+	router      *Router
+	middlewares []func(ctx *Context)
+}
+
+// Use 添加分组中间件，仅对之后注册的消息生效
+func (g *RouterGroup) Use(middleware ...func(ctx *Context)) {
+	g.middlewares = append(g.middlewares, middleware...)
+}
+
+// Group 创建子分组，继承当前分组的中间件
+func (g *RouterGroup) Group(middlewares ...func(ctx *Context)) *RouterGroup {
+	return &RouterGroup{
+		router:      g.router,
+		middlewares: g.combine(middlewares),
+	}
+}
+
+func (g *RouterGroup) Register(msgId int32, handlers ...func(ctx *Context)) {
+	g.router.Register(msgId, g.combine(handlers)...)
+}
+
+// combine 将分组中间件和handlers合并为新的切片
+func (g *RouterGroup) combine(handlers []func(ctx *Context)) []func(ctx *Context) {
+	merged := make([]func(ctx *Context), 0, len(g.middlewares)+len(handlers))
+	merged = append(merged, g.middlewares...)
+	return append(merged, handlers...)
+}
+
+// handle 执行消息处理链并处理记录的错误，没有可执行的处理函数时返回false
+func (r *Router) handle(c *Context) bool {
+	c.handlers = r.handlerChain(c.MsgID())
+	if len(c.handlers) == 0 {
+		return false
+	}
+	c.Next()
+	if len(c.errors) > 0 && r.errorHandler != nil {
+		r.errorHandler(c, c.errors[len(c.errors)-1])
+	}
+	return true
+}
@@ -2,8 +2,9 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
-	"io"
+	"fmt"
 	"log"
 	"net"
 	"runtime"
@@ -14,18 +15,42 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// defaultShutdownTimeout 收到退出信号或监听出错时等待处理完成的最长时间
+const defaultShutdownTimeout = 10 * time.Second
+
 type Server struct {
-	listener *net.TCPListener
+	listener net.Listener
 	rate     *rate.Limiter
 
+	// 在线会话，key为会话id
+	sessionMu      sync.RWMutex
+	sessions       map[uint64]*Session
+	sessionsClosed bool
+
+	// 优雅关闭
+	mu              sync.Mutex
+	quit            chan struct{} // Shutdown开始时关闭
+	done            chan struct{} // Shutdown完成时关闭
+	shutdownTimeout time.Duration
+	goAway          *goAway
+
 	connBase
 }
 
+// goAway 关闭前通知客户端的消息
+type goAway struct {
+	msgID int32
+	body  []byte
+}
+
 func NewServer() *Server {
-	s := &Server{}
+	s := &Server{
+		sessions: make(map[uint64]*Session),
+	}
 	s.showLog = true
 	s.state = StateInit
 	s.hbInterval = 10 * time.Second
+	s.shutdownTimeout = defaultShutdownTimeout
 	s.wg = sync.WaitGroup{}
 	s.SetWorker(runtime.NumCPU() * 10)
 	return s
@@ -47,108 +72,308 @@ func (s *Server) SetCPS(n int) {
 	}
 }
 
-func (s *Server) Shutdown() {
-	s.stopChan <- errors.New("shutdown")
+// SetGoAway 设置关闭前发送给所有客户端的消息
+func (s *Server) SetGoAway(msgID int32, body []byte) {
+	s.goAway = &goAway{msgID: msgID, body: body}
+}
+
+// SetShutdownTimeout 设置收到退出信号或监听出错时等待处理完成的最长时间，默认10秒
+func (s *Server) SetShutdownTimeout(d time.Duration) {
+	s.shutdownTimeout = d
 }
 
-func (s *Server) Listen(addr string) (*net.TCPListener, error) {
+// Shutdown 优雅关闭服务
+// 停止接受新连接，发送SetGoAway设置的消息，等待正在执行的处理函数和发送队列完成后关闭所有连接；
+// ctx到期时强制关闭剩余连接并返回ctx.Err()，仍在TLS握手的连接在握手结束后关闭。Serve随后返回ErrServerClosed
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.quit == nil {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	select {
+	case <-s.quit:
+		// 已经在关闭中，等待完成
+		done := s.done
+		s.mu.Unlock()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	default:
+	}
+	close(s.quit)
+	done := s.done
+	s.state = StateShuttingDown
+	s.mu.Unlock()
+	defer close(done)
+
+	// 停止接受新连接
+	_ = s.listener.Close()
+	s.beforeShutdown()
+
+	// 通知客户端
+	if s.goAway != nil {
+		s.broadcast(ctx, s.goAway.msgID, nil, s.goAway.body)
+	}
+
+	// 等待正在执行的处理函数
 	var err error
-	s.addr, err = net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		return nil, err
+	select {
+	case <-s.shutdownWorker():
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// 等待发送队列中的消息发送完成
+	if err == nil && s.queueSize > 0 {
+		for _, session := range s.Sessions() {
+			if ferr := session.Flush(ctx); ferr != nil && ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+		}
+	}
+
+	// 关闭剩余连接
+	s.sessionMu.Lock()
+	s.sessionsClosed = true
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.sessionMu.Unlock()
+	for _, session := range sessions {
+		_ = session.CloseWithError(ErrServerClosed)
+	}
+	// 等待连接goroutine退出，TLS握手中的连接最多需要等待握手超时，ctx到期时不再等待
+	waited := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
 	}
-	s.listener, err = net.ListenTCP("tcp", s.addr)
+
+	s.mu.Lock()
+	s.state = StateTerminate
+	s.listener = nil
+	s.quit = nil
+	s.mu.Unlock()
+	return err
+}
+
+func (s *Server) Listen(addr string) (net.Listener, error) {
+	var err error
+	s.listener, err = net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	s.addr = s.listener.Addr()
 	return s.listener, nil
 }
 
+// ServeListener 在指定的listener上提供服务，可用于Unix domain socket等非TCP传输
+func (s *Server) ServeListener(l net.Listener, router *Router) error {
+	s.listener = l
+	s.addr = l.Addr()
+	return s.Serve(router)
+}
+
 func (s *Server) Serve(router *Router) error {
+	s.mu.Lock()
 	if s.listener == nil {
+		s.mu.Unlock()
 		return errors.New("listener is nil")
 	}
-	if s.state == StateRunning {
+	if s.quit != nil {
+		s.mu.Unlock()
 		return errors.New("server is running")
 	}
 	s.state = StateRunning
-	defer func() {
-		s.worker.Shutdown()
-		s.wg.Wait()
-		s.listener.Close()
-		s.listener = nil
-		s.state = StateTerminate
-	}()
-
 	s.router = router
-	s.stopChan = make(chan error)
-	defer close(s.stopChan)
+	s.stopChan = make(chan error, 2)
+	s.quit = make(chan struct{})
+	s.done = make(chan struct{})
+	quit, done := s.quit, s.done
+	s.sessionsClosed = false
+	s.renewWorker()
+	s.mu.Unlock()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go s.handleSignals(ctx)
+	go s.accept(ctx, s.listener, quit)
 
-	var exited bool
-	go func() {
-		for {
-			if s.rate != nil {
-				_ = s.rate.Wait(ctx)
-			}
-			conn, err := s.listener.AcceptTCP()
-			if err != nil {
-				if exited {
-					return
-				}
+	var err error
+	select {
+	case err = <-s.stopChan:
+		// 监听出错或收到退出信号
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+		_ = s.Shutdown(shutdownCtx)
+	case <-quit:
+		err = ErrServerClosed
+	}
+	<-done
+	return err
+}
+
+func (s *Server) accept(ctx context.Context, listener net.Listener, quit chan struct{}) {
+	for {
+		if s.rate != nil {
+			_ = s.rate.Wait(ctx)
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-quit:
+			default:
 				s.stopChan <- err
-				return
 			}
-			err = s.configureConnection(conn)
-			if err != nil {
-				logger.Errorw("Configure connection error", "error", err)
-				_ = conn.Close()
-				continue
-			}
-			session := NewSession(conn)
-			go s.readHandler(ctx, session)
-			go s.onConnected(NewContext(session, nil))
+			return
 		}
-	}()
-
-	err := <-s.stopChan
-	exited = true
-	s.beforeShutdown()
-	return err
+		err = s.configureConnection(conn)
+		if err != nil {
+			logger.Errorw("Configure connection error", "error", err)
+			_ = conn.Close()
+			continue
+		}
+		// Shutdown在s.mu下关闭quit后才开始wg.Wait，此后到达的连接直接丢弃
+		s.mu.Lock()
+		select {
+		case <-quit:
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		default:
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		// TLS握手可能较慢，放到单独的goroutine中避免阻塞accept
+		go s.serveConn(conn)
+	}
 }
 
-func (s *Server) readHandler(ctx context.Context, session *Session) {
-	s.wg.Add(1)
+func (s *Server) serveConn(conn net.Conn) {
 	defer s.wg.Done()
-	exitChan := make(chan struct{})
-	go func() {
-		var err error
-		for {
-			var msg *Message
-			msg, err = ReadMsg(session)
-			if errors.Is(err, io.EOF) {
-				// 对端关闭了连接
-				break
-			}
-			if errors.Is(err, net.ErrClosed) {
-				// 本端关闭了连接
-				break
-			}
-			if err != nil {
-				break
-			}
-			s.onMessage(session, msg)
+	if s.tlsConfig != nil {
+		tlsConn := tls.Server(conn, s.tlsConfig)
+		err := s.handshake(tlsConn)
+		if err != nil {
+			logger.Warnw("TLS handshake error", "remote", conn.RemoteAddr().String(), "error", err)
+			_ = conn.Close()
+			return
 		}
-		s.onDisconnected(session, err)
-		close(exitChan)
-	}()
-	select {
-	case <-ctx.Done():
-		return
-	case <-exitChan:
+		conn = tlsConn
+	}
+	session := s.newSession(conn)
+	if !s.addSession(session) {
+		_ = session.CloseWithError(ErrServerClosed)
 		return
 	}
+	go s.onConnected(s.newContext(session, nil))
+	s.readHandler(session)
+}
+
+func (s *Server) readHandler(session *Session) {
+	var err error
+	for {
+		var msg *Message
+		msg, err = ReadMsg(session)
+		if err != nil {
+			// io.EOF 对端关闭了连接，net.ErrClosed 本端关闭了连接
+			break
+		}
+		s.onMessage(session, msg)
+	}
+	_ = session.Close()
+	s.removeSession(session)
+	if cause := session.closeCause(); cause != nil {
+		err = cause
+	}
+	s.onDisconnected(session, err)
+}
+
+// broadcast 向所有在线会话发送消息
+func (s *Server) broadcast(ctx context.Context, msgID int32, headers, body []byte) error {
+	sessions := s.Sessions()
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := WriteMsgWithContext(ctx, session, msgID, headers, body)
+			if err != nil {
+				errs[i] = fmt.Errorf("session %d: %w", session.ID(), err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// addSession 登记新会话，服务关闭中返回false
+func (s *Server) addSession(session *Session) bool {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if s.sessionsClosed {
+		return false
+	}
+	s.sessions[session.ID()] = session
+	return true
+}
+
+func (s *Server) removeSession(session *Session) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	delete(s.sessions, session.ID())
+}
+
+// Session 根据会话id查找在线会话
+func (s *Server) Session(id uint64) (*Session, bool) {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
+	session, ok := s.sessions[id]
+	return session, ok
+}
+
+// Sessions 返回所有在线会话
+func (s *Server) Sessions() []*Session {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// SessionCount 返回在线连接数
+func (s *Server) SessionCount() int {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
+	return len(s.sessions)
+}
+
+// Kick 断开指定会话，SetOnDisconnect收到的错误为ErrKicked
+func (s *Server) Kick(id uint64, reason string) error {
+	session, ok := s.Session(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return session.CloseWithError(fmt.Errorf("%w: %s", ErrKicked, reason))
+}
+
+// Broadcast 向所有在线会话发送消息，返回发送失败的错误
+func (s *Server) Broadcast(msgID int32, headers, body []byte) error {
+	return s.broadcast(context.Background(), msgID, headers, body)
 }
@@ -0,0 +1,302 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeListenerUnix(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "gotcp.sock"))
+	if err != nil {
+		t.Skip(err)
+	}
+
+	got := make(chan string, 1)
+	r := NewRouter()
+	r.Register(1, func(c *Context) {
+		got <- c.Text()
+	})
+	s := NewServer()
+	go s.ServeListener(l, r)
+
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient()
+	c.SetOnConnected(func(c *Context) {
+		_ = c.SendText(1, "hello")
+	})
+	go c.ConnectConn(conn, NewRouter())
+
+	select {
+	case text := <-got:
+		if text != "hello" {
+			t.Fatalf("got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	_ = c.Close()
+}
+
+// dialRaw 连接服务端，返回未启动读取的会话
+func dialRaw(t *testing.T, addr string) *Session {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return NewSession(conn)
+}
+
+// waitSessions 等待服务端的在线会话数达到n
+func waitSessions(t *testing.T, s *Server, n int) []*Session {
+	deadline := time.Now().Add(time.Second)
+	for s.SessionCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("session count %d, want %d", s.SessionCount(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return s.Sessions()
+}
+
+func TestShutdownFlushQueues(t *testing.T) {
+	s := NewServer()
+	s.SetWriteQueue(1024, OverflowBlock)
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(NewRouter())
+
+	var clients []*Session
+	for range 3 {
+		c := dialRaw(t, l.Addr().String())
+		_ = c.Conn().(*net.TCPConn).SetReadBuffer(16 * 1024)
+		clients = append(clients, c)
+	}
+	const count = 50
+	body := make([]byte, 64*1024)
+	// 客户端还没有开始读取，缩小socket缓冲区使消息堆积在发送队列中
+	for _, session := range waitSessions(t, s, len(clients)) {
+		_ = session.Conn().(*net.TCPConn).SetWriteBuffer(16 * 1024)
+		for range count {
+			if err := WriteMsg(session, 1, nil, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+
+	// 依次读取，前一个客户端读完之前其他会话的队列中都还有消息
+	for i, c := range clients {
+		for n := range count {
+			if _, err := ReadMsg(c); err != nil {
+				t.Fatalf("client %d received %d messages, want %d: %v", i, n, count, err)
+			}
+		}
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	const goAwayID = 100
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	r := NewRouter()
+	r.Register(1, func(c *Context) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		close(finished)
+	})
+	s := NewServer()
+	s.SetGoAway(goAwayID, []byte("bye"))
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := make(chan error, 1)
+	go func() { serve <- s.Serve(r) }()
+
+	c := dialRaw(t, l.Addr().String())
+	if err := WriteMsg(c, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Shutdown返回时处理函数已经执行完
+	select {
+	case <-finished:
+	default:
+		t.Fatal("handler still running after Shutdown")
+	}
+	if err := <-serve; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve returned %v", err)
+	}
+
+	// 客户端先收到goaway消息，然后连接被关闭
+	msg, err := ReadMsg(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID() != goAwayID || string(msg.Body()) != "bye" {
+		t.Fatalf("got %s", msg)
+	}
+	if _, err := ReadMsg(c); err == nil {
+		t.Fatal("connection still open")
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Fatal("listener still accepting")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s := NewServer()
+	// 不发送ClientHello的连接停留在TLS握手
+	s.SetTLSConfig(&tls.Config{})
+	s.SetHandshakeTimeout(10 * time.Second)
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(NewRouter())
+	dialRaw(t, l.Addr().String())
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Shutdown took %v", d)
+	}
+}
+
+func TestSessionRegistry(t *testing.T) {
+	disconnected := make(chan error, 2)
+	s := NewServer()
+	s.SetOnDisconnect(func(_ *Session, err error) {
+		disconnected <- err
+	})
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(NewRouter())
+	defer s.Shutdown(context.Background())
+
+	// 连接后登记会话
+	c1 := dialRaw(t, l.Addr().String())
+	c2 := dialRaw(t, l.Addr().String())
+	sessions := waitSessions(t, s, 2)
+	for _, session := range sessions {
+		if got, ok := s.Session(session.ID()); !ok || got != session {
+			t.Fatalf("session %d not found", session.ID())
+		}
+	}
+
+	// 断开后移除会话
+	_ = c1.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	remain := waitSessions(t, s, 1)[0]
+	for _, session := range sessions {
+		if _, ok := s.Session(session.ID()); ok != (session == remain) {
+			t.Fatalf("session %d registered: %v", session.ID(), ok)
+		}
+	}
+
+	if err := s.Broadcast(1, nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ReadMsg(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Body()) != "hello" {
+		t.Fatalf("got %q", msg.Body())
+	}
+
+	// 踢下线
+	if err := s.Kick(remain.ID(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-disconnected:
+		if !errors.Is(err, ErrKicked) {
+			t.Fatalf("got %v, want ErrKicked", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	waitSessions(t, s, 0)
+	if err := s.Kick(remain.ID(), "test"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("got %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestShutdownBusyWorker(t *testing.T) {
+	started := make(chan struct{}, 1)
+	r := NewRouter()
+	r.Register(1, func(c *Context) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// 会话被强制关闭后返回
+		<-c.Done()
+	})
+	s := NewServer()
+	s.SetWorker(1)
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(r)
+
+	// worker和任务队列都已占满，读goroutine阻塞在dispatch
+	c := dialRaw(t, l.Addr().String())
+	for range 6 {
+		if err := WriteMsg(c, 1, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+	select {
+	case err := <-shutdown:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown ignored ctx")
+	}
+}
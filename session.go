@@ -1,39 +1,262 @@
 package tcp
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// sessionSeq 会话id生成器
+var sessionSeq uint64
+
+// defaultReadBufferSize 会话读缓冲区默认大小
+const defaultReadBufferSize = 4096
+
 type Session struct {
-	conn *net.TCPConn
+	id   uint64
+	conn net.Conn
+
+	// 读取帧使用的缓冲读取器和帧头scratch缓冲区，只在读goroutine中使用
+	reader *bufio.Reader
+	rbuf   [16]byte
+	pooled bool // 消息的header和body从bufferPool分配
+
+	framing  framing // 帧格式，读写共用，创建后不再修改
+	checksum bool    // 发送时添加校验和，接收时要求校验和
 
 	closeChan chan error
+	closeOnce sync.Once
+	closeErr  error // 关闭原因
+
+	// 会话关闭时取消
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// 异步发送队列，为nil时同步发送
+	queue *writeQueue
+
+	// Send使用的编解码器，由Server/Client设置为路由的默认编解码器
+	codec Codec
+
+	// 空闲超时，由Server/Client设置
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	lastRead     atomic.Int64 // 最后一次收到消息的时间(UnixNano)
+	unknown      atomic.Int64 // 收到的未注册消息数
+
+	// 等待响应的Call，key为关联id
+	callSeq uint32
+	callMu  sync.Mutex
+	calls   map[uint32]chan *Message
+
+	// 会话属性
+	attrMu sync.RWMutex
+	attrs  map[string]any
 
 	sync.RWMutex
 }
 
-func NewSession(conn *net.TCPConn) *Session {
-	return &Session{
+func NewSession(conn net.Conn) *Session {
+	s := &Session{
+		id:        atomic.AddUint64(&sessionSeq, 1),
 		conn:      conn,
+		reader:    bufio.NewReaderSize(conn, defaultReadBufferSize),
+		framing:   defaultFraming,
 		closeChan: make(chan error, 1),
+		calls:     make(map[uint32]chan *Message),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.lastRead.Store(time.Now().UnixNano())
+	return s
+}
+
+// Context 返回会话的context，会话关闭(包括服务关闭时强制关闭连接)后被取消
+func (s *Session) Context() context.Context {
+	return s.ctx
 }
 
-func (s *Session) Conn() *net.TCPConn {
+// ID 会话id，进程内唯一
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+func (s *Session) Conn() net.Conn {
 	return s.conn
 }
 
+// TLSState 返回TLS连接状态，非TLS连接返回false
+func (s *Session) TLSState() (*tls.ConnectionState, bool) {
+	conn, ok := s.conn.(*tls.Conn)
+	if !ok {
+		return nil, false
+	}
+	state := conn.ConnectionState()
+	return &state, true
+}
+
+// PeerCertificate 返回已通过验证的对端证书，未启用TLS或对端证书未经验证时返回nil
+func (s *Session) PeerCertificate() *x509.Certificate {
+	state, ok := s.TLSState()
+	if !ok || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
 func (s *Session) Remote() string {
 	return s.conn.RemoteAddr().String()
 }
 
 func (s *Session) Close() error {
-	s.Lock()
-	defer s.Unlock()
-	if s.conn != nil {
-		err := s.conn.Close()
+	return s.CloseWithError(nil)
+}
+
+// CloseWithError 关闭会话并记录关闭原因，SetOnDisconnect回调会收到该原因
+func (s *Session) CloseWithError(cause error) error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.closeErr = cause
+		close(s.closeChan)
+		s.cancel()
+		// 不需要获取写锁，避免被阻塞中的写操作卡住
+		if s.conn != nil {
+			err = s.conn.Close()
+		}
+	})
+	return err
+}
+
+// Set 设置会话属性，在会话的整个生命周期内有效
+func (s *Session) Set(key string, value any) {
+	s.attrMu.Lock()
+	defer s.attrMu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+}
+
+// Get 获取会话属性
+func (s *Session) Get(key string) (value any, exists bool) {
+	s.attrMu.RLock()
+	defer s.attrMu.RUnlock()
+	value, exists = s.attrs[key]
+	return
+}
+
+// Delete 删除会话属性
+func (s *Session) Delete(key string) {
+	s.attrMu.Lock()
+	defer s.attrMu.Unlock()
+	delete(s.attrs, key)
+}
+
+// send，可以在任意goroutine中并发调用，会话关闭后返回ErrSessionClosed
+
+// Send 使用路由的默认编解码器编码并发送
+func (s *Session) Send(msgID int32, v any) error {
+	codec := s.codec
+	if codec == nil {
+		codec = CodecJSON
+	}
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return WriteMsg(s, msgID, nil, b)
+}
+
+func (s *Session) SendJSON(msgID int32, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
 		return err
 	}
-	return nil
+	return WriteMsg(s, msgID, nil, b)
+}
+
+func (s *Session) SendText(msgID int32, data string) error {
+	return WriteMsg(s, msgID, nil, []byte(data))
+}
+
+func (s *Session) SendStream(msgID int32, headers interface{}, data []byte) error {
+	bHeader, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	return WriteMsg(s, msgID, bHeader, data)
+}
+
+// closeCause 返回CloseWithError记录的关闭原因
+func (s *Session) closeCause() error {
+	select {
+	case <-s.closeChan:
+		return s.closeErr
+	default:
+		return nil
+	}
+}
+
+// Call 发送请求并等待对端通过Context.Reply返回的响应
+// ctx超时或会话关闭时返回错误，对端通过Context.SendError响应时返回*Error
+func (s *Session) Call(ctx context.Context, msgID int32, body []byte) (*Message, error) {
+	seq, ch := s.addCall()
+	defer s.removeCall(seq)
+
+	err := writeMsg(ctx, s, msgID, flagRequest, seq, nil, body)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.id == MsgIDError {
+			e := &Error{}
+			err = json.Unmarshal(msg.body, e)
+			if err != nil {
+				return nil, err
+			}
+			return nil, e
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.closeChan:
+		return nil, ErrSessionClosed
+	}
+}
+
+func (s *Session) addCall() (uint32, chan *Message) {
+	ch := make(chan *Message, 1)
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	s.callSeq++
+	if s.callSeq == 0 {
+		s.callSeq++
+	}
+	s.calls[s.callSeq] = ch
+	return s.callSeq, ch
+}
+
+func (s *Session) removeCall(seq uint32) {
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	delete(s.calls, seq)
+}
+
+// resolveCall 将响应交给等待中的Call，没有对应的Call时返回false
+func (s *Session) resolveCall(msg *Message) bool {
+	s.callMu.Lock()
+	ch, ok := s.calls[msg.seq]
+	delete(s.calls, msg.seq)
+	s.callMu.Unlock()
+	if ok {
+		ch <- msg
+	}
+	return ok
 }
@@ -0,0 +1,144 @@
+package tcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCA 测试用的内存CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 签发证书，server为true时签发127.0.0.1的服务端证书
+func (ca *testCA) issue(t *testing.T, name string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	peer := make(chan string, 1)
+	r := NewRouter()
+	r.Register(1, func(c *Context) {
+		if _, ok := c.TLSState(); !ok {
+			peer <- "not tls"
+			return
+		}
+		peer <- c.PeerCertificate().Subject.CommonName
+	})
+
+	s := NewServer()
+	s.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", true)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	})
+	l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(r)
+	defer s.Shutdown(context.Background())
+
+	// 客户端通过ServerName为空时使用的连接地址校验服务端证书
+	c := NewClient()
+	c.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "client", false)},
+		RootCAs:      ca.pool,
+	})
+	connected := make(chan *Context, 1)
+	c.SetOnConnected(func(ctx *Context) {
+		connected <- ctx
+	})
+	go c.Connect(l.Addr().String(), NewRouter())
+	defer c.Close()
+
+	select {
+	case ctx := <-connected:
+		if ctx.PeerCertificate().Subject.CommonName != "server" {
+			t.Fatalf("server certificate %q", ctx.PeerCertificate().Subject.CommonName)
+		}
+		if err := ctx.SendText(1, "hello"); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case name := <-peer:
+		if name != "client" {
+			t.Fatalf("client certificate %q", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	// 没有客户端证书时握手失败
+	c2 := NewClient()
+	c2.SetTLSConfig(&tls.Config{RootCAs: ca.pool})
+	// TLS 1.3中客户端可能在服务端校验证书前完成握手，随后读取时收到服务端的alert
+	if err := c2.Connect(l.Addr().String(), NewRouter()); err == nil {
+		t.Fatal("connected without client certificate")
+	}
+	if n := s.SessionCount(); n != 1 {
+		t.Fatalf("session count %d, want 1", n)
+	}
+}
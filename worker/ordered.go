@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Ordered 按key保序执行任务的worker
+// 相同key的任务总是分配到同一个队列，由同一个goroutine按提交顺序串行执行；
+// 不同key的任务分散到多个队列并行执行
+type Ordered struct {
+	wg     sync.WaitGroup
+	queues []chan func()
+	n      int64
+}
+
+// NewOrdered create a new ordered worker
+// n: number of queues (one goroutine per queue)
+// l: length of each queue
+func NewOrdered(n, l int) *Ordered {
+	if n <= 0 {
+		n = 1
+	}
+	o := Ordered{
+		queues: make([]chan func(), n),
+	}
+	for i := range o.queues {
+		jobs := make(chan func(), l)
+		o.queues[i] = jobs
+		o.wg.Add(1)
+		go func() {
+			defer o.wg.Done()
+			for job := range jobs {
+				run(&o.n, job)
+			}
+		}()
+	}
+	return &o
+}
+
+// StartJob 提交任务，相同key的任务按提交顺序执行
+func (o *Ordered) StartJob(key uint64, job func()) {
+	o.queues[key%uint64(len(o.queues))] <- job
+}
+
+// StartJobUntil 提交任务，队列已满时阻塞直到任务入队或quit关闭，quit关闭时返回false
+func (o *Ordered) StartJobUntil(key uint64, job func(), quit <-chan struct{}) bool {
+	select {
+	case o.queues[key%uint64(len(o.queues))] <- job:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+func (o *Ordered) Shutdown() chan struct{} {
+	for _, jobs := range o.queues {
+		close(jobs)
+	}
+	ch := make(chan struct{}, 1)
+	go func() {
+		o.wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+func (o *Ordered) Status() {
+	var pending int
+	for _, jobs := range o.queues {
+		pending += len(jobs)
+	}
+	n := atomic.LoadInt64(&o.n)
+	log.Printf("[%d] jobs running, [%d] jobs pendding", n, pending)
+}
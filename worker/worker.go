@@ -2,8 +2,11 @@ package worker
 
 import (
 	"log"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
+
+	"github.com/myeof/gotcp/pkg/logger"
 )
 
 type Worker struct {
@@ -29,16 +32,7 @@ func NewWorker(n, l int) *Worker {
 				if !ok {
 					return
 				}
-				func() {
-					defer func() {
-						if err := recover(); err != nil {
-							log.Printf("panic: %v", err)
-						}
-					}()
-					atomic.AddInt64(&w.n, 1)
-					defer atomic.AddInt64(&w.n, -1)
-					job()
-				}()
+				run(&w.n, job)
 			}
 		}()
 	}
@@ -49,6 +43,16 @@ func (w *Worker) StartJob(job func()) {
 	w.jobs <- job
 }
 
+// StartJobUntil 提交任务，队列已满时阻塞直到任务入队或quit关闭，quit关闭时返回false
+func (w *Worker) StartJobUntil(job func(), quit <-chan struct{}) bool {
+	select {
+	case w.jobs <- job:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
 func (w *Worker) Shutdown() chan struct{} {
 	close(w.jobs)
 	ch := make(chan struct{}, 1)
@@ -63,3 +67,15 @@ func (w *Worker) Status() {
 	n := atomic.LoadInt64(&w.n)
 	log.Printf("[%d] jobs running, [%d] jobs pendding", n, len(w.jobs))
 }
+
+// run 执行任务，统计运行数并捕获panic
+func run(n *int64, job func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorw("Worker panic", "error", err, "stack", string(debug.Stack()))
+		}
+	}()
+	atomic.AddInt64(n, 1)
+	defer atomic.AddInt64(n, -1)
+	job()
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"sync"
 	"testing"
 	"time"
 )
@@ -20,3 +21,48 @@ func TestInitWorker(t *testing.T) {
 	<-w.Shutdown()
 	t.Log("Worker shutdown")
 }
+
+func TestOrderedWorker(t *testing.T) {
+	w := NewOrdered(4, 4)
+	var mu sync.Mutex
+	got := make(map[uint64][]int)
+	for i := range 100 {
+		key := uint64(i % 3)
+		w.StartJob(key, func() {
+			if i%7 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			mu.Lock()
+			got[key] = append(got[key], i)
+			mu.Unlock()
+		})
+	}
+	<-w.Shutdown()
+	for key, seq := range got {
+		for j := 1; j < len(seq); j++ {
+			if seq[j] < seq[j-1] {
+				t.Fatalf("key %d out of order: %v", key, seq)
+			}
+		}
+	}
+}
+
+func TestStartJobUntil(t *testing.T) {
+	block := make(chan struct{})
+	w := NewWorker(1, 1)
+	o := NewOrdered(1, 1)
+	quit := make(chan struct{})
+	// 占满worker和队列
+	for range 2 {
+		if !w.StartJobUntil(func() { <-block }, quit) || !o.StartJobUntil(0, func() { <-block }, quit) {
+			t.Fatal("job not started")
+		}
+	}
+	time.AfterFunc(10*time.Millisecond, func() { close(quit) })
+	if w.StartJobUntil(func() {}, quit) || o.StartJobUntil(0, func() {}, quit) {
+		t.Fatal("job started after quit")
+	}
+	close(block)
+	<-w.Shutdown()
+	<-o.Shutdown()
+}
@@ -0,0 +1,262 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy 发送队列满时的处理策略
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // 阻塞直到队列有空位、ctx结束或会话关闭
+	OverflowDropNewest                       // 丢弃当前消息，返回ErrQueueFull
+	OverflowDropOldest                       // 丢弃队列中最早的消息
+	OverflowClose                            // 关闭会话，SetOnDisconnect收到ErrQueueFull
+)
+
+// outFrame 待发送的帧
+type outFrame struct {
+	head []byte // 从bufferPool获取，发送后归还
+	body []byte
+	tail []byte // 校验和，与head共用缓冲区
+
+	flushed chan struct{} // 不为nil时为flush标记，不发送数据
+}
+
+// writeQueue 会话的异步发送队列，由单独的goroutine写入连接
+type writeQueue struct {
+	frames  chan *outFrame
+	policy  OverflowPolicy
+	batch   int // 一次写入最多合并的帧数
+	dropped atomic.Uint64
+}
+
+// maxBatchBytes 合并写入时单次写入的最大字节数
+const maxBatchBytes = 256 * 1024
+
+// startWriter 开启异步发送队列
+func (s *Session) startWriter(capacity int, policy OverflowPolicy, batch int) {
+	if batch < 1 {
+		batch = 1
+	}
+	s.queue = &writeQueue{
+		frames: make(chan *outFrame, capacity),
+		policy: policy,
+		batch:  batch,
+	}
+	go s.writeLoop()
+}
+
+// writeLoop 从队列取出帧并写入连接，队列中有多个帧时合并为一次写入，写入失败时关闭会话
+func (s *Session) writeLoop() {
+	batch := make([]*outFrame, 0, s.queue.batch)
+	for {
+		var f *outFrame
+		select {
+		case <-s.closeChan:
+			s.discardQueue()
+			return
+		case f = <-s.queue.frames:
+		}
+		if f.flushed != nil {
+			close(f.flushed)
+			continue
+		}
+
+		// 取出队列中已有的帧，遇到flush标记时停止，保证标记之前的帧已经写入
+		batch = append(batch[:0], f)
+		size := len(f.head) + len(f.body)
+		var flushed chan struct{}
+	collect:
+		for len(batch) < s.queue.batch && size < maxBatchBytes {
+			select {
+			case f = <-s.queue.frames:
+				if f.flushed != nil {
+					flushed = f.flushed
+					break collect
+				}
+				batch = append(batch, f)
+				size += len(f.head) + len(f.body)
+			default:
+				break collect
+			}
+		}
+
+		s.Lock()
+		err := s.writeFrames(time.Time{}, false, batch...)
+		s.Unlock()
+		for i, f := range batch {
+			bufferPool.Put(f.head)
+			batch[i] = nil
+		}
+		if flushed != nil {
+			close(flushed)
+		}
+		if err != nil {
+			_ = s.CloseWithError(err)
+		}
+	}
+}
+
+// discardQueue 会话关闭后丢弃队列中剩余的帧
+func (s *Session) discardQueue() {
+	for {
+		select {
+		case f := <-s.queue.frames:
+			if f.flushed != nil {
+				close(f.flushed)
+				continue
+			}
+			bufferPool.Put(f.head)
+		default:
+			return
+		}
+	}
+}
+
+// enqueue 将帧放入发送队列，队列满时按策略处理
+func (s *Session) enqueue(ctx context.Context, f *outFrame) error {
+	q := s.queue
+	select {
+	case q.frames <- f:
+		return nil
+	default:
+	}
+
+	switch q.policy {
+	case OverflowDropNewest:
+		q.dropped.Add(1)
+		return ErrQueueFull
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.frames <- f:
+				return nil
+			case old := <-q.frames:
+				if old.flushed != nil {
+					// flush标记不能丢弃
+					close(old.flushed)
+					continue
+				}
+				bufferPool.Put(old.head)
+				q.dropped.Add(1)
+			}
+		}
+	case OverflowClose:
+		_ = s.CloseWithError(ErrQueueFull)
+		return ErrQueueFull
+	default:
+		select {
+		case q.frames <- f:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.closeChan:
+			return ErrSessionClosed
+		}
+	}
+}
+
+// Flush 等待发送队列中已有的消息发送完成，未开启发送队列时直接返回
+func (s *Session) Flush(ctx context.Context) error {
+	if s.queue == nil {
+		return nil
+	}
+	select {
+	case <-s.closeChan:
+		return ErrSessionClosed
+	default:
+	}
+	ch := make(chan struct{})
+	select {
+	case s.queue.frames <- &outFrame{flushed: ch}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.closeChan:
+		return ErrSessionClosed
+	}
+	// 会话关闭后发送goroutine不再处理flush标记
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.closeChan:
+		return ErrSessionClosed
+	}
+}
+
+// QueueLen 发送队列中等待发送的消息数
+func (s *Session) QueueLen() int {
+	if s.queue == nil {
+		return 0
+	}
+	return len(s.queue.frames)
+}
+
+// QueueCap 发送队列容量，未开启发送队列时为0
+func (s *Session) QueueCap() int {
+	if s.queue == nil {
+		return 0
+	}
+	return cap(s.queue.frames)
+}
+
+// Dropped 因队列满被丢弃的消息数
+func (s *Session) Dropped() uint64 {
+	if s.queue == nil {
+		return 0
+	}
+	return s.queue.dropped.Load()
+}
+
+// writeFrames 将帧写入连接，调用方需持有会话写锁
+// deadline为零值时只受写超时(SetIdleTimeout)限制
+func (s *Session) writeFrames(deadline time.Time, hasDeadline bool, frames ...*outFrame) error {
+	// 设置写入超时
+	var idle bool
+	if s.writeTimeout > 0 {
+		idleDeadline := time.Now().Add(s.writeTimeout)
+		if !hasDeadline || idleDeadline.Before(deadline) {
+			deadline, hasDeadline, idle = idleDeadline, true, true
+		}
+	}
+	if hasDeadline {
+		err := s.conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return err
+		}
+		defer s.conn.SetWriteDeadline(time.Time{})
+	}
+
+	// header和body通过writev一次写入
+	bufs := make(net.Buffers, 0, len(frames)*3)
+	for _, f := range frames {
+		bufs = append(bufs, f.head)
+		if len(f.body) > 0 {
+			bufs = append(bufs, f.body)
+		}
+		if len(f.tail) > 0 {
+			bufs = append(bufs, f.tail)
+		}
+	}
+	n, err := bufs.WriteTo(s.conn)
+	if err != nil {
+		if idle && errors.Is(err, os.ErrDeadlineExceeded) {
+			// 对端长时间不读取，消息可能只发送了一部分，只能关闭连接
+			_ = s.CloseWithError(ErrWriteTimeout)
+			return ErrWriteTimeout
+		}
+		if n > 0 {
+			// 帧只发送了一部分(如Context的截止时间到期)，继续发送会破坏数据流，只能关闭连接
+			_ = s.CloseWithError(err)
+		}
+		return err
+	}
+	return nil
+}
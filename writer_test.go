@@ -0,0 +1,119 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriteQueueDropNewest(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	session := NewSession(p1)
+	defer session.Close()
+	session.startWriter(1, OverflowDropNewest, 1)
+
+	// 第一条消息被发送goroutine取出后阻塞在写入，第二条留在队列中
+	if err := WriteMsg(session, 1, nil, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for session.QueueLen() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := WriteMsg(session, 1, nil, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMsg(session, 1, nil, []byte("3")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v, want ErrQueueFull", err)
+	}
+	if session.Dropped() != 1 {
+		t.Fatalf("dropped %d, want 1", session.Dropped())
+	}
+
+	go io.Copy(io.Discard, p2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := session.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if session.QueueLen() != 0 {
+		t.Fatalf("queue len %d after flush", session.QueueLen())
+	}
+}
+
+func TestWriteQueueClose(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	session := NewSession(p1)
+	session.startWriter(1, OverflowClose, 1)
+
+	var err error
+	for range 3 {
+		if err = WriteMsg(session, 1, nil, []byte("x")); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrQueueFull) || !errors.Is(session.closeCause(), ErrQueueFull) {
+		t.Fatalf("got %v, cause %v", err, session.closeCause())
+	}
+}
+
+func TestWritePartialFrameCloses(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	session := NewSession(p1)
+
+	// 对端只读取帧的一部分
+	go func() {
+		_, _ = io.ReadFull(p2, make([]byte, 10))
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := WriteMsgWithContext(ctx, session, 1, nil, make([]byte, 100))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	if !errors.Is(session.closeCause(), os.ErrDeadlineExceeded) {
+		t.Fatalf("session not closed, cause %v", session.closeCause())
+	}
+
+	// 一个字节都没有发送时连接保持可用
+	p3, p4 := net.Pipe()
+	defer p4.Close()
+	session = NewSession(p3)
+	defer session.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = WriteMsgWithContext(ctx, session, 1, nil, nil); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	if session.closeCause() != nil {
+		t.Fatalf("session closed: %v", session.closeCause())
+	}
+}
+
+func TestFlushClosed(t *testing.T) {
+	for range 50 {
+		p1, p2 := net.Pipe()
+		session := NewSession(p1)
+		session.startWriter(4, OverflowBlock, 1)
+		_ = session.Close()
+		_ = p2.Close()
+
+		done := make(chan error, 1)
+		go func() { done <- session.Flush(context.Background()) }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrSessionClosed) {
+				t.Fatalf("got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Flush blocked on closed session")
+		}
+	}
+}